@@ -0,0 +1,274 @@
+package compiler
+
+import (
+	"lsp/lsp"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// the markdown syntax tree. Parse builds it from a document following commonmark, plus the github
+// extensions for tables, task lists, strikethrough and footnotes. every node knows exactly where
+// it came from in the source, ranges are byte offsets like everywhere else in the compiler
+
+type NodeKind int
+
+const (
+	// blocks
+	DocumentNode NodeKind = iota
+	BlockQuoteNode
+	ListNode
+	ItemNode
+	ParagraphNode
+	HeadingNode
+	ThematicBreakNode
+	CodeBlockNode
+	HTMLBlockNode
+	DefinitionNode // [label]: destination "title"
+	FootnoteDefinitionNode
+	TableNode
+	TableRowNode
+	TableCellNode
+
+	// inlines
+	TextNode
+	SoftBreakNode
+	HardBreakNode
+	CodeSpanNode
+	EmphasisNode
+	StrongNode
+	StrikethroughNode
+	LinkNode
+	ImageNode
+	HTMLInlineNode
+	FootnoteReferenceNode
+)
+
+var nodeKindNames = [...]string{
+	"document", "block_quote", "list", "item", "paragraph", "heading", "thematic_break", "code_block",
+	"html_block", "definition", "footnote_definition", "table", "table_row", "table_cell",
+	"text", "softbreak", "linebreak", "code", "emph", "strong", "strikethrough", "link", "image",
+	"html_inline", "footnote_reference",
+}
+
+func (k NodeKind) String() string {
+	if int(k) < len(nodeKindNames) {
+		return nodeKindNames[k]
+	}
+	return "unknown"
+}
+
+// IsBlock is true for everything that isn't inline content
+func (k NodeKind) IsBlock() bool {
+	return k < TextNode
+}
+
+type Alignment int
+
+const (
+	AlignNone Alignment = iota
+	AlignLeft
+	AlignCenter
+	AlignRight
+)
+
+// Node is one element of the tree. only the fields that make sense for its Kind are filled in
+type Node struct {
+	Kind     NodeKind
+	Range    lsp.Range // End is exclusive
+	Parent   *Node     // top-level blocks Reparse kept from an older tree still point at its root
+	Children []*Node
+
+	// text, code spans, code blocks and html
+	Literal string
+
+	// headings, 1 to 6
+	Level int
+
+	// code blocks, Info is what comes after the opening fence
+	Fenced bool
+	Info   string
+
+	// lists, Bullet is 0 for ordered lists and Delimiter is '.' or ')' for them
+	Ordered   bool
+	ListStart int
+	Tight     bool
+	Bullet    byte
+	Delimiter byte
+
+	// task list items
+	Task    bool
+	Checked bool
+
+	// links, images and reference definitions. Label is the reference label as written, for
+	// reference links it is what got looked up, for definitions and footnotes what defines it
+	Destination string
+	Title       string
+	Label       string
+
+	// tables, Alignments is per column on the table and Header marks the first row
+	Alignments []Alignment
+	Header     bool
+
+	// block parser state, see blocks.go
+	open          bool
+	lines         []sourceLine
+	fenceChar     byte
+	fenceLength   int
+	fenceOffset   int
+	htmlBlockType int
+	markerOffset  int
+	padding       int
+	cellBudget    int
+
+	// inline parser state, offsets into the inline source and, while a block's inlines are
+	// parsed, its children as a linked list. see inlines.go
+	start, end              int
+	previous, next          *Node
+	firstInline, lastInline *Node
+}
+
+// AST is a parsed document
+type AST struct {
+	Root *Node
+
+	// reference definitions and footnote definitions by normalized label, the first one wins
+	Definitions map[string]*Node
+	Footnotes   map[string]*Node
+}
+
+func newNode(kind NodeKind) *Node {
+	return &Node{Kind: kind}
+}
+
+func (n *Node) appendChild(child *Node) {
+	child.Parent = n
+	n.Children = append(n.Children, child)
+}
+
+func (n *Node) lastChild() *Node {
+	if len(n.Children) == 0 {
+		return nil
+	}
+	return n.Children[len(n.Children)-1]
+}
+
+// index is where n is in its parent. it looks from the end, the block parser only moves the
+// block it just finished and that is the last one
+func (n *Node) index() int {
+	if n.Parent == nil {
+		return -1
+	}
+	for i := len(n.Parent.Children) - 1; i >= 0; i-- {
+		if n.Parent.Children[i] == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// unlink takes n out of its parent
+func (n *Node) unlink() {
+	if i := n.index(); i >= 0 {
+		n.Parent.Children = slices.Delete(n.Parent.Children, i, i+1)
+	}
+	n.Parent = nil
+}
+
+func (n *Node) insertBefore(sibling *Node) {
+	i := n.index()
+	sibling.Parent = n.Parent
+	n.Parent.Children = append(n.Parent.Children[:i], append([]*Node{sibling}, n.Parent.Children[i:]...)...)
+}
+
+func (n *Node) insertAfter(sibling *Node) {
+	i := n.index()
+	sibling.Parent = n.Parent
+	n.Parent.Children = append(n.Parent.Children[:i+1], append([]*Node{sibling}, n.Parent.Children[i+1:]...)...)
+}
+
+// Walk calls fn for n and everything below it, depth first in document order. returning false
+// from fn skips the children of that node
+func (n *Node) Walk(fn func(node *Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for _, child := range n.Children {
+		child.Walk(fn)
+	}
+}
+
+// Text is the plain text of n and its children, without any markup
+func (n *Node) Text() string {
+	var text strings.Builder
+	n.Walk(func(node *Node) bool {
+		switch node.Kind {
+		case TextNode, CodeSpanNode:
+			text.WriteString(node.Literal)
+		case SoftBreakNode, HardBreakNode:
+			text.WriteByte(' ')
+		}
+		return true
+	})
+	return text.String()
+}
+
+// NodeAt is the innermost node at position. a cursor right after a node still counts as on it,
+// unless the next node starts there
+func (a *AST) NodeAt(position lsp.Position) *Node {
+	node := a.Root
+	for {
+		var next, touching *Node
+		for _, child := range node.Children {
+			if !positionBefore(position, child.Range.Start) && positionBefore(position, child.Range.End) {
+				next = child
+				break
+			}
+			if touching == nil && position == child.Range.End {
+				touching = child
+			}
+		}
+		if next == nil {
+			next = touching
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+}
+
+// Find gives back every node of kind, in document order
+func (a *AST) Find(kind NodeKind) []*Node {
+	nodes := []*Node{}
+	a.Root.Walk(func(node *Node) bool {
+		if node.Kind == kind {
+			nodes = append(nodes, node)
+		}
+		return true
+	})
+	return nodes
+}
+
+// Ancestor is the closest node above n (or n itself) of kind, nil if there is none
+func (n *Node) Ancestor(kind NodeKind) *Node {
+	for node := n; node != nil; node = node.Parent {
+		if node.Kind == kind {
+			return node
+		}
+	}
+	return nil
+}
+
+func positionBefore(a, b lsp.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+}
+
+var whitespaceRun = regexp.MustCompile(`[ \t\r\n]+`)
+
+// NormalizeLabel is how reference labels compare: case doesn't matter and whitespace runs count as one space
+func NormalizeLabel(label string) string {
+	label = whitespaceRun.ReplaceAllString(strings.TrimSpace(label), " ")
+	// lower then upper so characters with more than one lower case form end up the same
+	return strings.ToUpper(strings.ReplaceAll(strings.ToLower(label), "ß", "ss"))
+}
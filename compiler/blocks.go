@@ -0,0 +1,936 @@
+package compiler
+
+import (
+	"lsp/lsp"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the block half of the parser, it follows the algorithm from the commonmark spec: every line first
+// walks down the open blocks to see which ones it continues, then looks for new blocks starting,
+// and whatever is left is text for the innermost block. inline content is parsed once every block
+// is done, see inlines.go
+
+// how far a line has to be indented to be code
+const codeIndent = 4
+
+// sourceLine is one line of content in a leaf block, col is the byte offset in the source line
+// where text starts
+type sourceLine struct {
+	row, col int
+	text     string
+}
+
+type blockParser struct {
+	ast *AST
+
+	tip                  *Node // the innermost open block
+	oldTip               *Node
+	lastMatchedContainer *Node
+	allClosed            bool
+
+	line                 string
+	row                  int
+	offset               int
+	column               int
+	nextNonspace         int
+	nextNonspaceColumn   int
+	indent               int
+	indented             bool
+	blank                bool
+	partiallyConsumedTab bool
+	lastLineLength       int
+
+	openEnded bool // see openEnded, set once every line is in
+}
+
+// Parse builds the syntax tree of a markdown document
+func Parse(text string) *AST {
+	lines := strings.Split(text, "\n")
+	p := parseBlocks(contentLines(lines), 0)
+	p.processInlines(p.ast.Root)
+	p.ast.Root.Range.End = offsetPosition(text, len(text))
+	return p.ast
+}
+
+// contentLines is lines without the empty one after a final newline, the newline ends the last
+// line, it doesn't start another one
+func contentLines(lines []string) []string {
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// parseBlocks runs the block half of the parser over lines as if they were a document of their
+// own, with rows counted from firstRow. inline content is left for processInlines
+func parseBlocks(lines []string, firstRow int) *blockParser {
+	root := newNode(DocumentNode)
+	root.open = true
+	root.Range.Start = lsp.Position{Line: firstRow}
+	p := &blockParser{
+		ast:       &AST{Root: root, Definitions: map[string]*Node{}, Footnotes: map[string]*Node{}},
+		tip:       root,
+		oldTip:    root,
+		allClosed: true,
+		row:       firstRow - 1,
+	}
+
+	for _, line := range lines {
+		p.incorporateLine(strings.TrimSuffix(line, "\r"))
+	}
+	p.openEnded = openEnded(root)
+	for p.tip != nil {
+		p.finalize(p.tip, p.row)
+	}
+	root.Range.End = lsp.Position{Line: max(p.row, firstRow), Character: p.lastLineLength}
+	return p
+}
+
+// openEnded is true if one of the blocks still open below block could take in lines that come
+// after a blank line: code that hasn't been closed, or a list or footnote that might go on
+func openEnded(block *Node) bool {
+	for block = block.lastChild(); block != nil && block.open; block = block.lastChild() {
+		switch block.Kind {
+		case ListNode, ItemNode, FootnoteDefinitionNode, CodeBlockNode:
+			return true
+		case HTMLBlockNode:
+			if block.htmlBlockType <= 5 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *blockParser) incorporateLine(line string) {
+	p.line = line
+	p.row++
+	p.offset, p.column = 0, 0
+	p.blank, p.partiallyConsumedTab = false, false
+
+	allMatched := true
+	container := p.ast.Root
+	p.oldTip = p.tip
+
+	// see which open blocks the line continues, innermost last
+	for {
+		last := container.lastChild()
+		if last == nil || !last.open {
+			break
+		}
+		container = last
+
+		p.findNextNonspace()
+		switch p.continues(container) {
+		case continueMatched:
+		case continueFailed:
+			container = container.Parent
+			allMatched = false
+		case continueDone:
+			p.lastLineLength = len(line)
+			return
+		}
+		if !allMatched {
+			break
+		}
+	}
+
+	p.allClosed = container == p.oldTip
+	p.lastMatchedContainer = container
+
+	// then see if new blocks start
+	matchedLeaf := container.Kind != ParagraphNode && acceptsLines(container.Kind)
+	for !matchedLeaf {
+		p.findNextNonspace()
+
+		// most lines are plain text, don't bother trying every block start for them
+		if !p.indented && !maybeSpecial.MatchString(p.line[p.nextNonspace:]) {
+			p.advanceNextNonspace()
+			break
+		}
+
+		result := startNone
+		for _, start := range blockStarts {
+			if result = start(p, container); result != startNone {
+				break
+			}
+		}
+		if result == startNone {
+			p.advanceNextNonspace()
+			break
+		}
+		container = p.tip
+		if result == startLeaf {
+			matchedLeaf = true
+		}
+	}
+
+	// what's left of the line is text
+	if !p.allClosed && !p.blank && p.tip.Kind == ParagraphNode {
+		p.addLine() // lazy paragraph continuation
+	} else {
+		p.closeUnmatchedBlocks()
+
+		switch kind := container.Kind; {
+		case acceptsLines(kind):
+			p.addLine()
+			if kind == HTMLBlockNode && container.htmlBlockType >= 1 && container.htmlBlockType <= 5 &&
+				htmlBlockClose[container.htmlBlockType].MatchString(p.line[p.offset:]) {
+				p.lastLineLength = len(line)
+				p.finalize(container, p.row)
+			}
+		case p.offset < len(line) && !p.blank:
+			p.addChild(ParagraphNode, p.offset)
+			p.advanceNextNonspace()
+			p.addLine()
+		}
+	}
+	p.lastLineLength = len(line)
+}
+
+func (p *blockParser) findNextNonspace() {
+	i, columns := p.offset, p.column
+	for i < len(p.line) {
+		if c := p.line[i]; c == ' ' {
+			columns++
+		} else if c == '\t' {
+			columns += 4 - columns%4
+		} else {
+			break
+		}
+		i++
+	}
+	p.blank = i == len(p.line)
+	p.nextNonspace, p.nextNonspaceColumn = i, columns
+	p.indent = columns - p.column
+	p.indented = p.indent >= codeIndent
+}
+
+func (p *blockParser) advanceNextNonspace() {
+	p.offset, p.column = p.nextNonspace, p.nextNonspaceColumn
+	p.partiallyConsumedTab = false
+}
+
+// advanceOffset moves count bytes ahead, or count columns if columns is set, which matters for tabs
+func (p *blockParser) advanceOffset(count int, columns bool) {
+	for count > 0 && p.offset < len(p.line) {
+		if p.line[p.offset] != '\t' {
+			p.partiallyConsumedTab = false
+			p.offset++
+			p.column++
+			count--
+			continue
+		}
+
+		toTab := 4 - p.column%4
+		if columns {
+			p.partiallyConsumedTab = toTab > count
+			advance := min(toTab, count)
+			p.column += advance
+			if !p.partiallyConsumedTab {
+				p.offset++
+			}
+			count -= advance
+		} else {
+			p.partiallyConsumedTab = false
+			p.column += toTab
+			p.offset++
+			count--
+		}
+	}
+}
+
+func (p *blockParser) addLine() {
+	text := p.line[p.offset:]
+	col := p.offset
+	if p.partiallyConsumedTab {
+		// the rest of a tab that was partly used up for indentation becomes spaces
+		text = strings.Repeat(" ", 4-p.column%4) + p.line[p.offset+1:]
+		col++
+	}
+	p.tip.lines = append(p.tip.lines, sourceLine{row: p.row, col: col, text: text})
+}
+
+func (p *blockParser) addChild(kind NodeKind, offset int) *Node {
+	for !canContain(p.tip.Kind, kind) {
+		p.finalize(p.tip, p.row-1)
+	}
+	block := newNode(kind)
+	block.open = true
+	block.Range.Start = lsp.Position{Line: p.row, Character: offset}
+	p.tip.appendChild(block)
+	p.tip = block
+	return block
+}
+
+func (p *blockParser) closeUnmatchedBlocks() {
+	if p.allClosed {
+		return
+	}
+	for p.oldTip != p.lastMatchedContainer {
+		parent := p.oldTip.Parent
+		p.finalize(p.oldTip, p.row-1)
+		p.oldTip = parent
+	}
+	p.allClosed = true
+}
+
+// finalize closes block, row is the last line that still belonged to it
+func (p *blockParser) finalize(block *Node, row int) {
+	above := block.Parent
+	block.open = false
+	block.Range.End = lsp.Position{Line: row, Character: p.lastLineLength}
+	if row == p.row {
+		block.Range.End.Character = len(p.line)
+	}
+
+	// leaf blocks end with their last line, blank lines after them aren't theirs
+	if len(block.lines) > 0 && acceptsLines(block.Kind) && !block.Fenced {
+		block.Range.End = block.lines[len(block.lines)-1].endPosition()
+	}
+
+	switch block.Kind {
+	case ParagraphNode:
+		p.finalizeParagraph(block)
+	case CodeBlockNode:
+		finalizeCodeBlock(block)
+	case HTMLBlockNode:
+		block.Literal = strings.TrimRight(joinLines(block.lines), "\n")
+	case TableNode:
+		finalizeTable(block)
+	case ListNode:
+		finalizeList(block)
+	}
+
+	// containers end where their last child does, not on the blank lines after it. block quotes
+	// can't go on over blank lines, but can end in a > line with nothing in it
+	if isContainer(block.Kind) && block.Kind != DocumentNode && block.Kind != BlockQuoteNode {
+		if last := block.lastChild(); last != nil {
+			block.Range.End = last.Range.End
+		} else if block.Kind == ItemNode {
+			block.Range.End = lsp.Position{Line: block.Range.Start.Line, Character: block.Range.Start.Character + block.padding}
+		}
+	}
+
+	p.tip = above
+}
+
+func (p *blockParser) finalizeParagraph(block *Node) {
+	source := newInlineSource(block.lines)
+	consumed := p.parseDefinitions(block, source)
+	if consumed == 0 {
+		return
+	}
+	if rest := source.text[consumed:]; strings.TrimSpace(rest) == "" {
+		block.unlink()
+		return
+	}
+	block.lines = source.slice(consumed)
+	block.Range.Start = block.lines[0].position()
+}
+
+// parseDefinitions takes link reference definitions off the start of a paragraph and puts them
+// in the tree in front of it. it returns how much of the paragraph they used up
+func (p *blockParser) parseDefinitions(block *Node, source *inlineSource) int {
+	consumed := 0
+	for consumed < len(source.text) && source.text[consumed] == '[' {
+		definition, n := parseDefinition(source.text[consumed:])
+		if n == 0 {
+			break
+		}
+		definition.Range = lsp.Range{
+			Start: source.position(consumed),
+			End:   source.position(consumed + len(strings.TrimRight(source.text[consumed:consumed+n], " \n"))),
+		}
+		block.insertBefore(definition)
+		if label := NormalizeLabel(definition.Label); p.ast.Definitions[label] == nil {
+			p.ast.Definitions[label] = definition
+		}
+		consumed += n
+	}
+	return consumed
+}
+
+func finalizeCodeBlock(block *Node) {
+	if !block.Fenced {
+		// trailing blank lines aren't part of indented code
+		lines := block.lines
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1].text) == "" {
+			lines = lines[:len(lines)-1]
+		}
+		block.Literal = joinLines(lines)
+		if len(lines) > 0 {
+			block.Range.End = lines[len(lines)-1].endPosition()
+		}
+		return
+	}
+
+	// the first line is the rest of the opening fence, the info string
+	block.Info = unescapeString(strings.TrimSpace(block.lines[0].text))
+	block.Literal = joinLines(block.lines[1:])
+}
+
+func finalizeList(block *Node) {
+	block.Tight = true
+	for i, item := range block.Children {
+		if i+1 < len(block.Children) && blankLineBetween(item, block.Children[i+1]) {
+			block.Tight = false
+			return
+		}
+		for j := 0; j+1 < len(item.Children); j++ {
+			if blankLineBetween(item.Children[j], item.Children[j+1]) {
+				block.Tight = false
+				return
+			}
+		}
+	}
+}
+
+// blankLineBetween is true if there is a blank line between two blocks that follow each other
+func blankLineBetween(block, next *Node) bool {
+	return block.Range.End.Line != next.Range.Start.Line-1
+}
+
+func joinLines(lines []sourceLine) string {
+	var text strings.Builder
+	for _, line := range lines {
+		text.WriteString(line.text)
+		text.WriteByte('\n')
+	}
+	return text.String()
+}
+
+func isContainer(kind NodeKind) bool {
+	switch kind {
+	case DocumentNode, BlockQuoteNode, ListNode, ItemNode, FootnoteDefinitionNode:
+		return true
+	}
+	return false
+}
+
+func canContain(parent, child NodeKind) bool {
+	switch parent {
+	case DocumentNode, BlockQuoteNode, ItemNode, FootnoteDefinitionNode:
+		return child != ItemNode
+	case ListNode:
+		return child == ItemNode
+	}
+	return false
+}
+
+func acceptsLines(kind NodeKind) bool {
+	return kind == ParagraphNode || kind == CodeBlockNode || kind == HTMLBlockNode || kind == TableNode
+}
+
+// continuing open blocks
+
+const (
+	continueMatched = iota
+	continueFailed
+	continueDone // the line was used up, like a closing code fence
+)
+
+var closingCodeFence = regexp.MustCompile("^(?:`{3,}|~{3,})[ \t]*$")
+
+func (p *blockParser) continues(block *Node) int {
+	switch block.Kind {
+	case BlockQuoteNode:
+		if p.indented || p.nextNonspace >= len(p.line) || p.line[p.nextNonspace] != '>' {
+			return continueFailed
+		}
+		p.advanceNextNonspace()
+		p.advanceOffset(1, false)
+		if p.offset < len(p.line) && isSpaceOrTab(p.line[p.offset]) {
+			p.advanceOffset(1, true)
+		}
+
+	case ItemNode:
+		switch {
+		case p.blank && len(block.Children) == 0:
+			return continueFailed // a blank line after an empty item ends it
+		case p.blank:
+			p.advanceNextNonspace()
+		case p.indent >= block.markerOffset+block.padding:
+			p.advanceOffset(block.markerOffset+block.padding, true)
+		default:
+			return continueFailed
+		}
+
+	case FootnoteDefinitionNode:
+		switch {
+		case p.blank:
+			p.advanceNextNonspace()
+		case p.indent >= codeIndent:
+			p.advanceOffset(codeIndent, true)
+		default:
+			return continueFailed
+		}
+
+	case HeadingNode, ThematicBreakNode, DefinitionNode:
+		return continueFailed
+
+	case CodeBlockNode:
+		if block.Fenced {
+			rest := p.line[p.nextNonspace:]
+			if p.indent <= 3 && len(rest) > 0 && rest[0] == block.fenceChar && closingCodeFence.MatchString(rest) &&
+				len(strings.TrimRight(rest, " \t")) >= block.fenceLength {
+				p.lastLineLength = len(p.line)
+				p.finalize(block, p.row)
+				return continueDone
+			}
+			// skip the indentation the opening fence had
+			for i := block.fenceOffset; i > 0 && p.offset < len(p.line) && isSpaceOrTab(p.line[p.offset]); i-- {
+				p.advanceOffset(1, true)
+			}
+		} else {
+			switch {
+			case p.indent >= codeIndent:
+				p.advanceOffset(codeIndent, true)
+			case p.blank:
+				p.advanceNextNonspace()
+			default:
+				return continueFailed
+			}
+		}
+
+	case HTMLBlockNode:
+		if p.blank && (block.htmlBlockType == 6 || block.htmlBlockType == 7) {
+			return continueFailed
+		}
+
+	case ParagraphNode:
+		if p.blank {
+			return continueFailed
+		}
+
+	case TableNode:
+		// a table goes on until a blank line or another block starts
+		if p.blank || !p.indented && interruptsTable.MatchString(p.line[p.nextNonspace:]) {
+			return continueFailed
+		}
+		// short rows get padded with empty cells, so a wide header over a lot of short rows can make
+		// far more cells than there is text. like cmark-gfm the table ends before padding takes over
+		row := p.line[p.nextNonspace:]
+		block.cellBudget += len(row) - max(0, len(block.Alignments)-len(splitTableRow(row, 0)))
+		if block.cellBudget < 0 {
+			return continueFailed
+		}
+	}
+	return continueMatched
+}
+
+// starting new blocks
+
+const (
+	startNone = iota
+	startContainer
+	startLeaf // a leaf block started, the rest of the line is its content
+)
+
+var (
+	maybeSpecial       = regexp.MustCompile("^[#`~*+_=<>0-9|:\\[-]")
+	atxHeadingMarker   = regexp.MustCompile("^#{1,6}(?:[ \t]+|$)")
+	atxClosingSequence = regexp.MustCompile("(?:^|[ \t]+)#+[ \t]*$")
+	codeFence          = regexp.MustCompile("^(?:`{3,}|~{3,})")
+	setextHeadingLine  = regexp.MustCompile("^(?:=+|-+)[ \t]*$")
+	thematicBreak      = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:_[ \t]*){3,}|(?:-[ \t]*){3,})$`)
+	bulletListMarker   = regexp.MustCompile(`^[*+-]`)
+	orderedListMarker  = regexp.MustCompile(`^(\d{1,9})([.)])`)
+	footnoteDefinition = regexp.MustCompile(`^\[\^([^\]\s]+)\]:`)
+	tableDelimiterRow  = regexp.MustCompile(`^\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	interruptsTable    = regexp.MustCompile("^(?:>|#{1,6}(?:[ \t]|$)|`{3,}|~{3,}|(?:(?:\\*[ \t]*){3,}|(?:_[ \t]*){3,}|(?:-[ \t]*){3,})$)")
+)
+
+var blockStarts = []func(p *blockParser, container *Node) int{
+	startBlockQuote,
+	startATXHeading,
+	startFencedCode,
+	startHTMLBlock,
+	startTable,
+	startSetextHeading,
+	startThematicBreak,
+	startFootnoteDefinition,
+	startListItem,
+	startIndentedCode,
+}
+
+func startBlockQuote(p *blockParser, container *Node) int {
+	if p.indented || p.line[p.nextNonspace] != '>' {
+		return startNone
+	}
+	p.advanceNextNonspace()
+	p.advanceOffset(1, false)
+	if p.offset < len(p.line) && isSpaceOrTab(p.line[p.offset]) {
+		p.advanceOffset(1, true)
+	}
+	p.closeUnmatchedBlocks()
+	p.addChild(BlockQuoteNode, p.nextNonspace)
+	return startContainer
+}
+
+func startATXHeading(p *blockParser, container *Node) int {
+	if p.indented {
+		return startNone
+	}
+	marker := atxHeadingMarker.FindString(p.line[p.nextNonspace:])
+	if marker == "" {
+		return startNone
+	}
+	p.advanceNextNonspace()
+	p.advanceOffset(len(marker), false)
+	p.closeUnmatchedBlocks()
+
+	heading := p.addChild(HeadingNode, p.nextNonspace)
+	heading.Level = len(strings.TrimRight(marker, " \t"))
+
+	content := p.line[p.offset:]
+	if loc := atxClosingSequence.FindStringIndex(content); loc != nil {
+		content = content[:loc[0]]
+	}
+	trimmed := strings.TrimLeft(content, " \t")
+	heading.lines = []sourceLine{{row: p.row, col: p.offset + len(content) - len(trimmed), text: strings.TrimRight(trimmed, " \t")}}
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return startLeaf
+}
+
+func startFencedCode(p *blockParser, container *Node) int {
+	if p.indented {
+		return startNone
+	}
+	rest := p.line[p.nextNonspace:]
+	fence := codeFence.FindString(rest)
+	if fence == "" || fence[0] == '`' && strings.Contains(rest[len(fence):], "`") {
+		return startNone
+	}
+	p.closeUnmatchedBlocks()
+	block := p.addChild(CodeBlockNode, p.nextNonspace)
+	block.Fenced = true
+	block.fenceLength = len(fence)
+	block.fenceChar = fence[0]
+	block.fenceOffset = p.indent
+	p.advanceNextNonspace()
+	p.advanceOffset(len(fence), false)
+	return startLeaf
+}
+
+func startHTMLBlock(p *blockParser, container *Node) int {
+	if p.indented || p.line[p.nextNonspace] != '<' {
+		return startNone
+	}
+	rest := p.line[p.nextNonspace:]
+	for kind := 1; kind <= 7; kind++ {
+		if !htmlBlockOpen[kind].MatchString(rest) {
+			continue
+		}
+		// the last kind can't interrupt a paragraph
+		if kind == 7 && (container.Kind == ParagraphNode || !p.allClosed && !p.blank && p.tip.Kind == ParagraphNode) {
+			return startNone
+		}
+		p.closeUnmatchedBlocks()
+		// the indentation is part of the html, so the block starts at offset
+		block := p.addChild(HTMLBlockNode, p.offset)
+		block.htmlBlockType = kind
+		return startLeaf
+	}
+	return startNone
+}
+
+// startTable turns the last line of a paragraph into the header of a table when a delimiter row
+// with the same number of cells comes after it
+func startTable(p *blockParser, container *Node) int {
+	if p.indented || container.Kind != ParagraphNode || len(container.lines) == 0 {
+		return startNone
+	}
+	delimiter := strings.TrimRight(p.line[p.nextNonspace:], " \t")
+	if !strings.Contains(delimiter, "|") || !tableDelimiterRow.MatchString(delimiter) {
+		return startNone
+	}
+	header := container.lines[len(container.lines)-1]
+	if len(splitTableRow(header.text, header.col)) != len(splitTableRow(delimiter, p.nextNonspace)) {
+		return startNone
+	}
+
+	// whatever came before the header stays a paragraph
+	parent := container.Parent
+	container.lines = container.lines[:len(container.lines)-1]
+	if len(container.lines) == 0 {
+		container.unlink()
+		p.tip = parent
+	} else {
+		p.finalize(container, header.row-1)
+	}
+
+	table := newNode(TableNode)
+	table.open = true
+	table.Range.Start = header.position()
+	table.lines = []sourceLine{header}
+	table.Alignments = parseAlignments(delimiter)
+	table.cellBudget = len(header.text) + len(delimiter)
+	parent.appendChild(table)
+	p.tip = table
+
+	// the delimiter row gets added as the table's second line
+	p.advanceNextNonspace()
+	return startLeaf
+}
+
+func startSetextHeading(p *blockParser, container *Node) int {
+	if p.indented || container.Kind != ParagraphNode || !setextHeadingLine.MatchString(p.line[p.nextNonspace:]) {
+		return startNone
+	}
+	// definitions at the start aren't part of the heading, if there is nothing else the line is
+	// just more paragraph
+	source := newInlineSource(container.lines)
+	if strings.TrimSpace(source.text[definitionLength(source.text):]) == "" {
+		return startNone
+	}
+	p.closeUnmatchedBlocks()
+	consumed := p.parseDefinitions(container, source)
+
+	heading := newNode(HeadingNode)
+	heading.open = true
+	heading.lines = source.slice(consumed)
+	heading.Range.Start = heading.lines[0].position()
+	heading.Level = 2
+	if p.line[p.nextNonspace] == '=' {
+		heading.Level = 1
+	}
+	container.insertAfter(heading)
+	container.unlink()
+	p.tip = heading
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return startLeaf
+}
+
+func startThematicBreak(p *blockParser, container *Node) int {
+	if p.indented || !thematicBreak.MatchString(p.line[p.nextNonspace:]) {
+		return startNone
+	}
+	p.closeUnmatchedBlocks()
+	p.addChild(ThematicBreakNode, p.nextNonspace)
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return startLeaf
+}
+
+func startFootnoteDefinition(p *blockParser, container *Node) int {
+	if p.indented {
+		return startNone
+	}
+	match := footnoteDefinition.FindStringSubmatch(p.line[p.nextNonspace:])
+	if match == nil {
+		return startNone
+	}
+	p.closeUnmatchedBlocks()
+	footnote := p.addChild(FootnoteDefinitionNode, p.nextNonspace)
+	footnote.Label = match[1]
+	if label := NormalizeLabel(match[1]); p.ast.Footnotes[label] == nil {
+		p.ast.Footnotes[label] = footnote
+	}
+	p.advanceNextNonspace()
+	p.advanceOffset(len(match[0]), false)
+	return startContainer
+}
+
+func startListItem(p *blockParser, container *Node) int {
+	if p.indented && container.Kind != ListNode {
+		return startNone
+	}
+	item := p.parseListMarker(container)
+	if item == nil {
+		return startNone
+	}
+	p.closeUnmatchedBlocks()
+
+	// a new list unless the item fits the one we're in
+	if p.tip.Kind != ListNode || !listsMatch(p.tip, item) {
+		list := p.addChild(ListNode, p.nextNonspace)
+		list.Ordered, list.Bullet, list.Delimiter, list.ListStart = item.Ordered, item.Bullet, item.Delimiter, item.ListStart
+	}
+	block := p.addChild(ItemNode, p.nextNonspace)
+	block.Ordered, block.Bullet, block.Delimiter, block.ListStart = item.Ordered, item.Bullet, item.Delimiter, item.ListStart
+	block.markerOffset, block.padding = item.markerOffset, item.padding
+	return startContainer
+}
+
+// parseListMarker reads a list marker and the spaces after it, it returns a node with just the
+// list data filled in, or nil if there is no list item here
+func (p *blockParser) parseListMarker(container *Node) *Node {
+	if p.indent >= codeIndent {
+		return nil
+	}
+	rest := p.line[p.nextNonspace:]
+	item := &Node{markerOffset: p.indent}
+
+	var marker string
+	if m := bulletListMarker.FindString(rest); m != "" {
+		marker = m
+		item.Bullet = m[0]
+	} else if m := orderedListMarker.FindStringSubmatch(rest); m != nil && (container.Kind != ParagraphNode || m[1] == "1") {
+		marker = m[0]
+		item.Ordered = true
+		item.ListStart, _ = strconv.Atoi(m[1])
+		item.Delimiter = m[2][0]
+	} else {
+		return nil
+	}
+
+	// there has to be a space after the marker
+	after := p.nextNonspace + len(marker)
+	if after < len(p.line) && !isSpaceOrTab(p.line[after]) {
+		return nil
+	}
+	// an empty item can't interrupt a paragraph
+	if container.Kind == ParagraphNode && strings.TrimSpace(p.line[after:]) == "" {
+		return nil
+	}
+
+	p.advanceNextNonspace()
+	p.advanceOffset(len(marker), true)
+	spacesStartColumn, spacesStartOffset := p.column, p.offset
+	for {
+		p.advanceOffset(1, true)
+		if p.column-spacesStartColumn >= 5 || p.offset >= len(p.line) || !isSpaceOrTab(p.line[p.offset]) {
+			break
+		}
+	}
+	blankItem := p.offset >= len(p.line)
+	spacesAfterMarker := p.column - spacesStartColumn
+
+	if spacesAfterMarker >= 5 || spacesAfterMarker < 1 || blankItem {
+		// too many spaces means indented code in the item, so only one of them counts
+		item.padding = len(marker) + 1
+		p.column, p.offset = spacesStartColumn, spacesStartOffset
+		if p.offset < len(p.line) && isSpaceOrTab(p.line[p.offset]) {
+			p.advanceOffset(1, true)
+		}
+	} else {
+		item.padding = len(marker) + spacesAfterMarker
+	}
+	return item
+}
+
+func listsMatch(list, item *Node) bool {
+	return list.Ordered == item.Ordered && list.Delimiter == item.Delimiter && list.Bullet == item.Bullet
+}
+
+func startIndentedCode(p *blockParser, container *Node) int {
+	if !p.indented || p.tip.Kind == ParagraphNode || p.blank {
+		return startNone
+	}
+	p.advanceOffset(codeIndent, true)
+	p.closeUnmatchedBlocks()
+	p.addChild(CodeBlockNode, p.offset)
+	return startLeaf
+}
+
+func isSpaceOrTab(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// tables
+
+// parseAlignments is the alignment of each column from the delimiter row
+func parseAlignments(delimiter string) []Alignment {
+	alignments := []Alignment{}
+	for _, cell := range splitTableRow(delimiter, 0) {
+		text := strings.TrimSpace(cell.text)
+		switch left, right := strings.HasPrefix(text, ":"), strings.HasSuffix(text, ":"); {
+		case left && right:
+			alignments = append(alignments, AlignCenter)
+		case left:
+			alignments = append(alignments, AlignLeft)
+		case right:
+			alignments = append(alignments, AlignRight)
+		default:
+			alignments = append(alignments, AlignNone)
+		}
+	}
+	return alignments
+}
+
+// finalizeTable splits the lines of a table into rows and cells. the first line is the header,
+// the second the delimiter row, the alignments are already known from it
+func finalizeTable(table *Node) {
+	for i, line := range table.lines {
+		if i == 1 {
+			continue
+		}
+		row := newNode(TableRowNode)
+		row.Header = i == 0
+		row.Range = lsp.Range{
+			Start: lsp.Position{Line: line.row, Character: line.col},
+			End:   lsp.Position{Line: line.row, Character: line.col + len(strings.TrimRight(line.text, " \t"))},
+		}
+		cells := splitTableRow(line.text, line.col)
+		for column := range table.Alignments {
+			cell := newNode(TableCellNode)
+			if column < len(cells) {
+				content := cells[column]
+				content.row = line.row
+				cell.lines = []sourceLine{content}
+				cell.Range = lsp.Range{Start: content.position(), End: content.endPosition()}
+			} else {
+				// missing cells are empty, they sit at the end of the row
+				cell.Range = lsp.Range{Start: row.Range.End, End: row.Range.End}
+			}
+			row.appendChild(cell)
+		}
+		table.appendChild(row)
+	}
+	table.lines = nil
+}
+
+// splitTableRow cuts a row into its cells on the pipes that aren't escaped, with the spaces
+// around each cell trimmed. col is where text starts in its source line
+func splitTableRow(text string, col int) []sourceLine {
+	trimmed := strings.TrimLeft(text, " \t")
+	col += len(text) - len(trimmed)
+	text = strings.TrimRight(trimmed, " \t")
+	if strings.HasPrefix(text, "|") {
+		text, col = text[1:], col+1
+	}
+	if strings.HasSuffix(text, "|") && !strings.HasSuffix(text, `\|`) {
+		text = text[:len(text)-1]
+	}
+
+	cells := []sourceLine{}
+	start := 0
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) && text[i] == '\\' {
+			i++
+			continue
+		}
+		if i < len(text) && text[i] != '|' {
+			continue
+		}
+		cell := text[start:i]
+		trimmed := strings.TrimLeft(cell, " \t")
+		cells = append(cells, sourceLine{col: col + start + len(cell) - len(trimmed), text: strings.TrimRight(trimmed, " \t")})
+		start = i + 1
+	}
+	return cells
+}
+
+// html blocks, the seven kinds from the spec. the index is the kind
+
+var htmlBlockOpen = []*regexp.Regexp{
+	nil,
+	regexp.MustCompile(`(?i)^<(?:script|pre|textarea|style)(?:\s|>|$)`),
+	regexp.MustCompile(`^<!--`),
+	regexp.MustCompile(`^<[?]`),
+	regexp.MustCompile(`^<![A-Za-z]`),
+	regexp.MustCompile(`^<!\[CDATA\[`),
+	regexp.MustCompile(`(?i)^</?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[123456]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:\s|/?>|$)`),
+	regexp.MustCompile(`(?i)^(?:` + openTag + `|` + closeTag + `)\s*$`),
+}
+
+var htmlBlockClose = []*regexp.Regexp{
+	nil,
+	regexp.MustCompile(`(?i)</(?:script|pre|textarea|style)>`),
+	regexp.MustCompile(`-->`),
+	regexp.MustCompile(`\?>`),
+	regexp.MustCompile(`>`),
+	regexp.MustCompile(`\]\]>`),
+}
@@ -0,0 +1,146 @@
+package compiler
+
+import (
+	"fmt"
+	"lsp/lsp"
+	"strings"
+	"sync"
+)
+
+// Snapshot is one version of a document. it never changes after it is made, an edit creates
+// a new snapshot instead, so a request can hold on to one while more edits keep coming in
+type Snapshot struct {
+	URI     string
+	Version int
+	Text    string
+
+	lines    []string                 // derived from Text once so every feature doesn't split it again
+	encoding lsp.PositionEncodingKind // what the client counts characters in, see position.go
+
+	// the State parses documents as they come in, snapshots made some other way get parsed the
+	// first time a feature needs it. see AST and Prose
+	parse      sync.Once
+	ast        *AST
+	proseLines sync.Once
+	prose      []string
+}
+
+func NewSnapshot(uri string, version int, text string, encoding lsp.PositionEncodingKind) *Snapshot {
+	return &Snapshot{
+		URI:      uri,
+		Version:  version,
+		Text:     text,
+		lines:    strings.Split(text, "\n"),
+		encoding: encoding,
+	}
+}
+
+// Lines is the text split on \n, it is shared between everyone using the snapshot so don't modify it
+func (s *Snapshot) Lines() []string {
+	return s.lines
+}
+
+// AST is the syntax tree of the document
+func (s *Snapshot) AST() *AST {
+	s.parse.Do(func() { s.ast = Parse(s.Text) })
+	return s.ast
+}
+
+// setAST hands the snapshot a tree that was already parsed for its text
+func (s *Snapshot) setAST(ast *AST) {
+	s.parse.Do(func() { s.ast = ast })
+}
+
+// Prose is Lines with everything that isn't prose blanked out with spaces: code, html, link
+// destinations and reference definitions. offsets still line up with Lines, so checks that look
+// for words can match on this and report ranges in the real text
+func (s *Snapshot) Prose() []string {
+	s.proseLines.Do(s.blankOutCode)
+	return s.prose
+}
+
+func (s *Snapshot) blankOutCode() {
+	prose := make([][]byte, len(s.lines))
+	for i, line := range s.lines {
+		prose[i] = []byte(line)
+	}
+	blank := func(r lsp.Range) {
+		for row := r.Start.Line; row <= r.End.Line && row < len(prose); row++ {
+			from, to := 0, len(prose[row])
+			if row == r.Start.Line {
+				from = min(r.Start.Character, to)
+			}
+			if row == r.End.Line {
+				to = min(r.End.Character, to)
+			}
+			for i := from; i < to; i++ {
+				prose[row][i] = ' '
+			}
+		}
+	}
+
+	s.AST().Root.Walk(func(node *Node) bool {
+		switch node.Kind {
+		case CodeBlockNode, HTMLBlockNode, CodeSpanNode, HTMLInlineNode, DefinitionNode, FootnoteReferenceNode:
+			blank(node.Range)
+			return false
+		case LinkNode, ImageNode:
+			if len(node.Children) == 0 || strings.HasPrefix(s.lines[node.Range.Start.Line][node.Range.Start.Character:], "<") {
+				blank(node.Range) // autolinks are all destination
+				return false
+			}
+			// the text of a link is prose, the destination after it isn't
+			blank(lsp.Range{Start: node.Children[len(node.Children)-1].Range.End, End: node.Range.End})
+		}
+		return true
+	})
+
+	s.prose = make([]string, len(prose))
+	for i, line := range prose {
+		s.prose[i] = string(line)
+	}
+}
+
+// applyChanges applies didChange events to text one after another, each range is relative to the
+// text as it is after the previous change. an event without a range replaces everything
+func applyChanges(text string, changes []lsp.TextDocumentContentChangeEvent, encoding lsp.PositionEncodingKind) (string, error) {
+	for _, change := range changes {
+		if change.Range == nil {
+			text = change.Text
+			continue
+		}
+
+		start := byteOffset(text, change.Range.Start, encoding)
+		end := byteOffset(text, change.Range.End, encoding)
+		if end < start {
+			return "", fmt.Errorf("invalid change range %d:%d-%d:%d, end is before start",
+				change.Range.Start.Line, change.Range.Start.Character, change.Range.End.Line, change.Range.End.Character)
+		}
+
+		text = text[:start] + change.Text + text[end:]
+	}
+
+	return text, nil
+}
+
+// byteOffset finds where a protocol position is in text without splitting the whole thing into lines.
+// lines past the end land on the end of the text
+func byteOffset(text string, position lsp.Position, encoding lsp.PositionEncodingKind) int {
+	start := 0
+	for line := 0; line < position.Line; line++ {
+		i := strings.IndexByte(text[start:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		start += i + 1
+	}
+
+	end := strings.IndexByte(text[start:], '\n')
+	if end < 0 {
+		end = len(text)
+	} else {
+		end += start
+	}
+
+	return start + characterToByte(text[start:end], position.Character, encoding)
+}
@@ -0,0 +1,85 @@
+package compiler_test
+
+import (
+	"lsp/compiler"
+	"lsp/lsp"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func insertAt(line, character int, text string) lsp.TextDocumentContentChangeEvent {
+	position := lsp.Position{Line: line, Character: character}
+	return lsp.TextDocumentContentChangeEvent{Range: &lsp.Range{Start: position, End: position}, Text: text}
+}
+
+// utf-16 column of the first occurrence of needle in line
+func column(line, needle string) int {
+	return len(utf16.Encode([]rune(line[:strings.Index(line, needle)])))
+}
+
+func TestIncrementalChangesMatchFullText(t *testing.T) {
+	full, err := os.ReadFile("../test.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := string(full)
+	lines := strings.Split(expected, "\n")
+
+	state := compiler.NewState()
+	state.OpenDocument("file:///test.md", 0, "")
+
+	// type the file in one line at a time, the last line has no \n after it
+	for i, line := range lines {
+		text := line
+		if i < len(lines)-1 {
+			text += "\n"
+		}
+		if _, err := state.ChangeDocument("file:///test.md", i+1, []lsp.TextDocumentContentChangeEvent{insertAt(i, 0, text)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot, _ := state.Snapshot("file:///test.md")
+	if snapshot.Text != expected {
+		t.Fatalf("Expected:\n%s\nActual:\n%s", expected, snapshot.Text)
+	}
+
+	// now a batch of edits in one didChange, each one relative to the result of the one before
+	row := 3 // "you’ve just opened **the** most underwhelming markdown file on the internet."
+	start := column(lines[row], "underwhelming")
+	changes := []lsp.TextDocumentContentChangeEvent{
+		{
+			Range: &lsp.Range{
+				Start: lsp.Position{Line: row, Character: start},
+				End:   lsp.Position{Line: row, Character: start + len("underwhelming")},
+			},
+			Text: "masterpiece",
+		},
+		insertAt(0, 0, "> hi\n"),
+		{
+			// drop "congratulations.  \n", which is now on line 3
+			Range: &lsp.Range{Start: lsp.Position{Line: 3, Character: 0}, End: lsp.Position{Line: 4, Character: 0}},
+			Text:  "",
+		},
+	}
+	if _, err := state.ChangeDocument("file:///test.md", 100, changes); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "> hi\n" + strings.Replace(strings.Replace(expected, "underwhelming", "masterpiece", 1), "congratulations.  \n", "", 1)
+	snapshot, _ = state.Snapshot("file:///test.md")
+	if snapshot.Text != expected {
+		t.Fatalf("Expected:\n%s\nActual:\n%s", expected, snapshot.Text)
+	}
+
+	// and a full text change still replaces everything
+	if _, err := state.ChangeDocument("file:///test.md", 101, []lsp.TextDocumentContentChangeEvent{{Text: "fresh"}}); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, _ = state.Snapshot("file:///test.md")
+	if snapshot.Text != "fresh" || snapshot.Version != 101 {
+		t.Fatalf("Expected: 'fresh' at version 101, Got: %q at version %d", snapshot.Text, snapshot.Version)
+	}
+}
@@ -0,0 +1,175 @@
+package compiler
+
+import (
+	"lsp/lsp"
+	"sort"
+	"strings"
+)
+
+// incremental parsing. most edits touch a line or two, so instead of parsing the whole document
+// again Reparse works out which top-level blocks an edit could have changed and parses only those.
+// blocks before the edit are shared with the old tree, blocks after it are copied with their rows
+// shifted. a region is only parsed on its own if blank lines separate it from the blocks around
+// it, otherwise it grows until they do
+
+// Reparse is the tree of newLines, made from old, the tree of oldLines, and just the part that
+// changed. both are the text split on \n. old isn't modified, but nodes from it can end up in the
+// new tree too, so neither should be
+func Reparse(old *AST, oldLines, newLines []string) *AST {
+	oldContent, newContent := contentLines(oldLines), contentLines(newLines)
+	end := lsp.Position{Line: len(newLines) - 1, Character: len(newLines[len(newLines)-1])}
+
+	// the lines the edit didn't touch at the start and the end
+	prefix := 0
+	for prefix < len(oldContent) && prefix < len(newContent) && oldContent[prefix] == newContent[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldContent)-prefix && suffix < len(newContent)-prefix &&
+		oldContent[len(oldContent)-1-suffix] == newContent[len(newContent)-1-suffix] {
+		suffix++
+	}
+	if prefix == len(oldContent) && prefix == len(newContent) {
+		root := *old.Root // at most a newline at the very end changed
+		root.Range.End = end
+		return &AST{Root: &root, Definitions: old.Definitions, Footnotes: old.Footnotes}
+	}
+
+	// old rows [start, stop) get parsed again, they are stop-start+delta rows now
+	blocks := old.Root.Children
+	delta := len(newContent) - len(oldContent)
+	start, stop := prefix, len(oldContent)-suffix
+	for {
+		// every block in the region or right next to it, without a blank line in between
+		first := sort.Search(len(blocks), func(i int) bool { return blocks[i].Range.End.Line >= start-1 })
+		last := sort.Search(len(blocks), func(i int) bool { return blocks[i].Range.Start.Line > stop })
+		if first < last && (blocks[first].Range.Start.Line < start || blocks[last-1].Range.End.Line >= stop) {
+			start = min(start, blocks[first].Range.Start.Line)
+			stop = max(stop, blocks[last-1].Range.End.Line+1)
+			continue // which can bring more blocks next to it
+		}
+
+		// a block before that can go on after a blank line might take in the region's first lines
+		if first > 0 && continuesPastBlank(blocks[first-1]) {
+			start = blocks[first-1].Range.Start.Line
+			continue
+		}
+
+		region := parseBlocks(newContent[start:stop+delta], start)
+
+		// and the region might take in the block after it, or the blank lines at the end
+		if region.openEnded && stop < len(oldContent) {
+			stop = len(oldContent)
+			if last < len(blocks) {
+				stop = blocks[last].Range.End.Line + 1
+			}
+			continue
+		}
+
+		// links everywhere depend on the definitions, if those changed everything has to be parsed again
+		if !sameDefinitions(blocks[first:last], region.ast.Root.Children) {
+			return Parse(strings.Join(newLines, "\n"))
+		}
+
+		return splice(old, region, blocks[:first], blocks[last:], delta, start, stop, end)
+	}
+}
+
+// continuesPastBlank is true for blocks that a line after a blank line can still be part of
+func continuesPastBlank(block *Node) bool {
+	return block.Kind == ListNode || block.Kind == FootnoteDefinitionNode || block.Kind == CodeBlockNode && !block.Fenced
+}
+
+// sameDefinitions compares the reference and footnote definitions in two lists of blocks
+func sameDefinitions(before, after []*Node) bool {
+	definitions := func(blocks []*Node) []Node {
+		found := []Node{}
+		for _, block := range blocks {
+			block.Walk(func(node *Node) bool {
+				switch node.Kind {
+				case DefinitionNode, FootnoteDefinitionNode:
+					found = append(found, Node{Kind: node.Kind, Label: NormalizeLabel(node.Label), Destination: node.Destination, Title: node.Title})
+				}
+				return node.Kind.IsBlock()
+			})
+		}
+		return found
+	}
+
+	a, b := definitions(before), definitions(after)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Kind != b[i].Kind || a[i].Label != b[i].Label || a[i].Destination != b[i].Destination || a[i].Title != b[i].Title {
+			return false
+		}
+	}
+	return true
+}
+
+// splice finishes parsing the region and puts it between the blocks from the old tree. old rows
+// [start, stop) are what the region replaced
+func splice(old *AST, region *blockParser, before, after []*Node, delta, start, stop int, end lsp.Position) *AST {
+	// the definitions are the same as before, but the ones in the region are new nodes
+	inRegion := func(node *Node) bool {
+		return node.Range.Start.Line >= start && node.Range.Start.Line < stop
+	}
+	merge := func(old, region map[string]*Node) map[string]*Node {
+		merged := make(map[string]*Node, len(old))
+		for label, node := range old {
+			merged[label] = node
+			if inRegion(node) {
+				merged[label] = region[label]
+			}
+		}
+		return merged
+	}
+	ast := region.ast
+	ast.Definitions = merge(old.Definitions, ast.Definitions)
+	ast.Footnotes = merge(old.Footnotes, ast.Footnotes)
+	region.processInlines(ast.Root)
+
+	root := ast.Root
+	children := make([]*Node, 0, len(before)+len(root.Children)+len(after))
+	children = append(children, before...)
+	children = append(children, root.Children...)
+	moved := map[*Node]*Node{}
+	for _, block := range after {
+		if delta != 0 {
+			block = block.shifted(delta, root, moved)
+		}
+		children = append(children, block)
+	}
+	root.Children = children
+	root.Range = lsp.Range{End: end}
+
+	// definitions after the region moved too
+	for _, definitions := range []map[string]*Node{ast.Definitions, ast.Footnotes} {
+		for label, node := range definitions {
+			if moved[node] != nil {
+				definitions[label] = moved[node]
+			}
+		}
+	}
+	return ast
+}
+
+// shifted is a copy of n and everything below it, delta rows further down. moved maps the
+// definitions in it to their copies
+func (n *Node) shifted(delta int, parent *Node, moved map[*Node]*Node) *Node {
+	node := *n
+	node.Parent = parent
+	node.Range.Start.Line += delta
+	node.Range.End.Line += delta
+	if len(n.Children) > 0 {
+		node.Children = make([]*Node, len(n.Children))
+		for i, child := range n.Children {
+			node.Children[i] = child.shifted(delta, &node, moved)
+		}
+	}
+	if n.Kind == DefinitionNode || n.Kind == FootnoteDefinitionNode {
+		moved[n] = &node
+	}
+	return &node
+}
@@ -0,0 +1,178 @@
+package compiler_test
+
+import (
+	"fmt"
+	"lsp/compiler"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// dump is tree with everything else a feature might look at: ranges, labels and the definitions
+func dump(ast *compiler.AST) string {
+	var out strings.Builder
+	ast.Root.Walk(func(node *compiler.Node) bool {
+		depth := 0
+		for parent := node.Parent; parent != nil && parent.Kind != compiler.DocumentNode; parent = parent.Parent {
+			depth++
+		}
+		fmt.Fprintf(&out, "%s%s %v %q %q %q %q %d %v %v %v\n", strings.Repeat("  ", depth), node.Kind, node.Range,
+			node.Literal, node.Label, node.Destination, node.Title, node.Level, node.Tight, node.Task, node.Checked)
+		return true
+	})
+	for _, definitions := range []map[string]*compiler.Node{ast.Definitions, ast.Footnotes} {
+		labels := []string{}
+		for label, node := range definitions {
+			labels = append(labels, fmt.Sprintf("%s %v", label, node.Range))
+		}
+		sort.Strings(labels)
+		fmt.Fprintln(&out, labels)
+	}
+	return out.String()
+}
+
+// the kinds of lines edits are made of, so blocks start, end and interrupt each other a lot
+var markdownLines = []string{
+	"", "", "", "some text", "more *text* and [a link][ref]", "a note[^n]", "# heading", "## another",
+	"===", "---", "- item", "  continued", "1. first", "2) second", "- [ ] task", "> quote", "> - quoted item",
+	"    code", "```go", "```", "~~~", "<div>", "</div>", "<!-- comment", "-->", "| a | b |", "| - | - |",
+	"[ref]: /somewhere", "[other]: /else \"title\"", "[^n]: footnote", "    footnote continued", "***",
+	"text with `code` and <http://auto.link>", "\tindented with a tab",
+}
+
+func randomLines(random *rand.Rand, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = markdownLines[random.Intn(len(markdownLines))]
+	}
+	return lines
+}
+
+// edit does what typing would: changes a line, or adds and removes a few
+func edit(random *rand.Rand, lines []string) []string {
+	row := random.Intn(len(lines))
+	edited := append([]string{}, lines[:row]...)
+	switch random.Intn(4) {
+	case 0:
+		line := lines[row]
+		at := random.Intn(len(line) + 1)
+		edited = append(edited, line[:at]+"x"+line[at:])
+		row++
+	case 1:
+		edited = append(edited, randomLines(random, 1+random.Intn(3))...)
+	case 2:
+		row += 1 + random.Intn(3)
+	case 3:
+		edited = append(edited, randomLines(random, 1+random.Intn(2))...)
+		row++
+	}
+	edited = append(edited, lines[min(row, len(lines)):]...)
+	if len(edited) == 0 {
+		return []string{""} // what splitting an empty document gives
+	}
+	return edited
+}
+
+func TestReparse(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for document := 0; document < 200; document++ {
+		lines := randomLines(random, 1+random.Intn(30))
+		ast := compiler.Parse(strings.Join(lines, "\n"))
+
+		// keep building on the reparsed trees so mistakes would pile up
+		for i := 0; i < 20; i++ {
+			edited := edit(random, lines)
+			before := strings.Join(lines, "\n")
+			ast = compiler.Reparse(ast, lines, edited)
+			lines = edited
+
+			text := strings.Join(lines, "\n")
+			if expected, got := dump(compiler.Parse(text)), dump(ast); got != expected {
+				t.Fatalf("Expected:\n%s\nGot:\n%s\nfor %q changed to %q", expected, got, before, text)
+			}
+		}
+	}
+}
+
+func TestReparseReusesNodes(t *testing.T) {
+	lines := []string{"# one", "", "some text", "", "# two", "", "more text"}
+	old := compiler.Parse(strings.Join(lines, "\n"))
+
+	edited := append([]string{}, lines...)
+	edited[2] = "some new text"
+	ast := compiler.Reparse(old, lines, edited)
+
+	if ast.Root.Children[0] != old.Root.Children[0] {
+		t.Fatalf("Expected: the heading before the edit shared with the old tree, Got: a new node")
+	}
+	if ast.Root.Children[1] == old.Root.Children[1] {
+		t.Fatalf("Expected: the edited paragraph parsed again, Got: the old one")
+	}
+	if got := ast.Root.Children[1].Text(); got != "some new text" {
+		t.Fatalf("Expected: some new text, Got: %s", got)
+	}
+}
+
+// bigDocument is about 10k lines of the sort of markdown the server sees
+func bigDocument() []string {
+	section := strings.Split(`## section
+
+some text with *emphasis*, **strong**, `+"`code`"+` and [a link](https://example.com).
+it goes on for a while, see [the docs][docs] for more[^note].
+
+- an item
+- [ ] a task
+  - nested
+
+`+"```go"+`
+func main() {}
+`+"```"+`
+
+> a quote
+> over two lines
+
+| a | b |
+| - | - |
+| 1 | 2 |
+`, "\n")
+
+	lines := []string{"# big", ""}
+	for len(lines) < 10000 {
+		lines = append(lines, section...)
+	}
+	return append(lines, "[docs]: https://example.com/docs", "[^note]: a footnote")
+}
+
+func BenchmarkParse(b *testing.B) {
+	text := strings.Join(bigDocument(), "\n")
+
+	for b.Loop() {
+		compiler.Parse(text)
+	}
+}
+
+// typing a character in the middle of the document
+func BenchmarkReparseTyping(b *testing.B) {
+	lines := bigDocument()
+	ast := compiler.Parse(strings.Join(lines, "\n"))
+	edited := append([]string{}, lines...)
+	edited[len(lines)/2] += "x"
+
+	for b.Loop() {
+		compiler.Reparse(ast, lines, edited)
+	}
+}
+
+// a new line in the middle of the document, everything after it moves down
+func BenchmarkReparseNewLine(b *testing.B) {
+	lines := bigDocument()
+	ast := compiler.Parse(strings.Join(lines, "\n"))
+	middle := len(lines) / 2
+	edited := append(append(append([]string{}, lines[:middle]...), "a new line"), lines[middle:]...)
+
+	for b.Loop() {
+		compiler.Reparse(ast, lines, edited)
+	}
+}
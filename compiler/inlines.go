@@ -0,0 +1,923 @@
+package compiler
+
+import (
+	"html"
+	"lsp/lsp"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// the inline half of the parser, it turns the text of paragraphs, headings and table cells into
+// emphasis, links, code spans and so on. it works on the lines of a block joined with newlines,
+// inlineSource knows where each byte of that came from
+
+type inlineSource struct {
+	text   string
+	lines  []sourceLine
+	starts []int // where each line starts in text
+}
+
+func newInlineSource(lines []sourceLine) *inlineSource {
+	source := &inlineSource{lines: lines}
+	var text strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			text.WriteByte('\n')
+		}
+		source.starts = append(source.starts, text.Len())
+		text.WriteString(line.text)
+	}
+	source.text = text.String()
+	return source
+}
+
+// position is where offset into text is in the document
+func (s *inlineSource) position(offset int) lsp.Position {
+	i := s.lineAt(offset)
+	if i < 0 {
+		return lsp.Position{}
+	}
+	return lsp.Position{Line: s.lines[i].row, Character: s.lines[i].col + offset - s.starts[i]}
+}
+
+// slice is the lines from offset on
+func (s *inlineSource) slice(offset int) []sourceLine {
+	i := s.lineAt(offset)
+	if i < 0 {
+		return nil
+	}
+	first := s.lines[i]
+	skip := min(offset-s.starts[i], len(first.text))
+	lines := []sourceLine{{row: first.row, col: first.col + skip, text: first.text[skip:]}}
+	return append(lines, s.lines[i+1:]...)
+}
+
+func (s *inlineSource) lineAt(offset int) int {
+	return sort.Search(len(s.starts), func(i int) bool { return s.starts[i] > offset }) - 1
+}
+
+func (l sourceLine) position() lsp.Position {
+	return lsp.Position{Line: l.row, Character: l.col}
+}
+
+func (l sourceLine) endPosition() lsp.Position {
+	return lsp.Position{Line: l.row, Character: l.col + len(l.text)}
+}
+
+// pieces of html, shared with the html blocks
+const (
+	tagName        = `[A-Za-z][A-Za-z0-9-]*`
+	attributeName  = `[a-zA-Z_:][a-zA-Z0-9:._-]*`
+	attributeValue = "(?:[^\"'=<>`\\x00-\\x20]+|'[^']*'|\"[^\"]*\")"
+	attribute      = `(?:\s+` + attributeName + `(?:\s*=\s*` + attributeValue + `)?)`
+	openTag        = `<` + tagName + attribute + `*\s*/?>`
+	closeTag       = `</` + tagName + `\s*>`
+	htmlComment    = `<!-->|<!--->|<!--[\s\S]*?-->`
+	processing     = `<\?[\s\S]*?\?>`
+	declaration    = `<![A-Za-z]+[^>]*>`
+	cdata          = `<!\[CDATA\[[\s\S]*?\]\]>`
+)
+
+var (
+	htmlTag        = regexp.MustCompile(`^(?:` + openTag + `|` + closeTag + `|` + htmlComment + `|` + processing + `|` + declaration + `|` + cdata + `)`)
+	entity         = regexp.MustCompile(`^&(?:#[xX][0-9a-fA-F]{1,6}|#[0-9]{1,7}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	escapeOrEntity = regexp.MustCompile(`\\[!-/:-@\[-` + "`" + `{-~]|&(?:#[xX][0-9a-fA-F]{1,6}|#[0-9]{1,7}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	uriAutolink    = regexp.MustCompile(`^<[A-Za-z][A-Za-z0-9.+-]{1,31}:[^<>\x00-\x20]*>`)
+	emailAutolink  = regexp.MustCompile(`^<[a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*>`)
+	linkLabel      = regexp.MustCompile(`^\[(?:[^\\\[\]]|\\[\s\S]){0,999}\]`)
+	linkTitle      = regexp.MustCompile(`^(?:"(?:\\[\s\S]|[^"\\])*"|'(?:\\[\s\S]|[^'\\])*'|\((?:\\[\s\S]|[^()\\])*\))`)
+	backtickRun    = regexp.MustCompile("`+")
+	plainText      = regexp.MustCompile("^[^\n`\\[\\]\\\\!<&*_~]+")
+	taskMarker     = regexp.MustCompile(`^\[[ xX]\][ \t]+`)
+)
+
+const escapable = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// unescapeString resolves backslash escapes and entities
+func unescapeString(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	return escapeOrEntity.ReplaceAllStringFunc(s, func(match string) string {
+		if match[0] == '\\' {
+			return match[1:]
+		}
+		return html.UnescapeString(match)
+	})
+}
+
+// an emphasis marker run that might open or close something
+type delimiter struct {
+	char              byte
+	count, origCount  int
+	node              *Node
+	previous, next    *delimiter
+	canOpen, canClose bool
+}
+
+// a [ or ![ that might start a link
+type bracket struct {
+	node              *Node
+	previous          *bracket
+	previousDelimiter *delimiter
+	index             int // where the [ is
+	image             bool
+	links             int // how many links the block had when it was opened
+	bracketAfter      bool
+}
+
+type inlineParser struct {
+	ast        *AST
+	block      *Node
+	subject    string
+	pos        int
+	delimiters *delimiter // the top of the stack
+	brackets   *bracket
+	links      int // how many links have been made so far
+
+	// where the runs of backticks of each length start, so finding the closing run of a code
+	// span doesn't go over the rest of the subject for every opening one
+	backticks map[int][]int
+}
+
+// processInlines parses the content of every leaf block that has inline content, the block
+// parser is done with all of them by now so every reference definition is known
+func (p *blockParser) processInlines(root *Node) {
+	root.Walk(func(node *Node) bool {
+		switch node.Kind {
+		case ParagraphNode, HeadingNode, TableCellNode:
+			if node.Kind == ParagraphNode && node.Parent.Kind == ItemNode && node.Parent.Children[0] == node {
+				parseTaskMarker(node)
+			}
+			parseInlines(p.ast, node)
+		}
+		node.lines = nil
+		return node.Kind.IsBlock()
+	})
+}
+
+// parseTaskMarker takes a [ ] or [x] off the start of the first paragraph in a list item
+func parseTaskMarker(paragraph *Node) {
+	first := paragraph.lines[0]
+	marker := taskMarker.FindString(first.text)
+	if marker == "" || len(marker) == len(first.text) {
+		return
+	}
+	item := paragraph.Parent
+	item.Task = true
+	item.Checked = marker[1] != ' '
+	paragraph.lines[0] = sourceLine{row: first.row, col: first.col + len(marker), text: first.text[len(marker):]}
+	paragraph.Range.Start = paragraph.lines[0].position()
+}
+
+func parseInlines(ast *AST, block *Node) {
+	source := newInlineSource(block.lines)
+	p := &inlineParser{ast: ast, block: block, subject: strings.TrimRight(source.text, " \t\n")}
+	p.pos = len(p.subject) - len(strings.TrimLeft(p.subject, " \t\n"))
+
+	for p.pos < len(p.subject) {
+		p.parseInline()
+	}
+	p.processEmphasis(nil)
+
+	collectInlines(block)
+	mergeText(block)
+	setRanges(block, source)
+}
+
+func (p *inlineParser) peek() byte {
+	if p.pos < len(p.subject) {
+		return p.subject[p.pos]
+	}
+	return 0
+}
+
+func (p *inlineParser) add(node *Node, start int) *Node {
+	node.start, node.end = start, p.pos
+	p.block.appendInline(node)
+	return node
+}
+
+// while a block's inlines are parsed its children are a linked list, emphasis and links take a
+// run of them without going over the rest of the block. collectInlines makes them Children
+
+func (n *Node) appendInline(child *Node) {
+	child.Parent, child.previous, child.next = n, n.lastInline, nil
+	if n.lastInline != nil {
+		n.lastInline.next = child
+	} else {
+		n.firstInline = child
+	}
+	n.lastInline = child
+}
+
+func (n *Node) insertInlineAfter(sibling *Node) {
+	sibling.Parent, sibling.previous, sibling.next = n.Parent, n, n.next
+	if n.next != nil {
+		n.next.previous = sibling
+	} else {
+		n.Parent.lastInline = sibling
+	}
+	n.next = sibling
+}
+
+func (n *Node) unlinkInline() {
+	if n.previous != nil {
+		n.previous.next = n.next
+	} else {
+		n.Parent.firstInline = n.next
+	}
+	if n.next != nil {
+		n.next.previous = n.previous
+	} else {
+		n.Parent.lastInline = n.previous
+	}
+	n.Parent, n.previous, n.next = nil, nil, nil
+}
+
+// adoptInlines moves the siblings from first to last into n, which has no children yet
+func (n *Node) adoptInlines(first, last *Node) {
+	parent := first.Parent
+	if first.previous != nil {
+		first.previous.next = last.next
+	} else {
+		parent.firstInline = last.next
+	}
+	if last.next != nil {
+		last.next.previous = first.previous
+	} else {
+		parent.lastInline = first.previous
+	}
+	first.previous, last.next = nil, nil
+	n.firstInline, n.lastInline = first, last
+	for child := first; child != nil; child = child.next {
+		child.Parent = n
+	}
+}
+
+func collectInlines(node *Node) {
+	for child := node.firstInline; child != nil; child = child.next {
+		collectInlines(child)
+		node.Children = append(node.Children, child)
+	}
+	for _, child := range node.Children {
+		child.previous, child.next = nil, nil
+	}
+	node.firstInline, node.lastInline = nil, nil
+}
+
+func (p *inlineParser) text(literal string, start int) *Node {
+	node := newNode(TextNode)
+	node.Literal = literal
+	return p.add(node, start)
+}
+
+func (p *inlineParser) parseInline() {
+	start := p.pos
+	handled := false
+	switch c := p.peek(); c {
+	case '\n':
+		handled = p.parseNewline()
+	case '\\':
+		handled = p.parseBackslash()
+	case '`':
+		handled = p.parseBackticks()
+	case '*', '_', '~':
+		handled = p.handleDelim(c)
+	case '[':
+		handled = p.parseOpenBracket()
+	case '!':
+		handled = p.parseBang()
+	case ']':
+		handled = p.parseCloseBracket()
+	case '<':
+		handled = p.parseAutolink() || p.parseHTMLTag()
+	case '&':
+		handled = p.parseEntity()
+	default:
+		handled = p.parseString()
+	}
+	if !handled {
+		p.pos++
+		p.text(p.subject[start:p.pos], start)
+	}
+}
+
+func (p *inlineParser) parseString() bool {
+	match := plainText.FindString(p.subject[p.pos:])
+	if match == "" {
+		return false
+	}
+	start := p.pos
+	p.pos += len(match)
+	p.text(match, start)
+	return true
+}
+
+// parseNewline makes a soft break, or a hard one if the line ended in two spaces
+func (p *inlineParser) parseNewline() bool {
+	start := p.pos
+	p.pos++
+	kind := SoftBreakNode
+	if last := p.block.lastInline; last != nil && last.Kind == TextNode && strings.HasSuffix(last.Literal, " ") {
+		trimmed := strings.TrimRight(last.Literal, " ")
+		if len(last.Literal)-len(trimmed) >= 2 {
+			kind = HardBreakNode
+		}
+		last.end -= len(last.Literal) - len(trimmed)
+		last.Literal = trimmed
+		if trimmed == "" {
+			last.unlinkInline()
+		}
+		if kind == HardBreakNode {
+			start = last.end
+		}
+	}
+	p.add(newNode(kind), start)
+
+	// spaces at the start of the next line don't count
+	for p.pos < len(p.subject) && isSpaceOrTab(p.subject[p.pos]) {
+		p.pos++
+	}
+	return true
+}
+
+func (p *inlineParser) parseBackslash() bool {
+	start := p.pos
+	p.pos++
+	switch c := p.peek(); {
+	case c == '\n':
+		p.pos++
+		p.add(newNode(HardBreakNode), start)
+	case c != 0 && strings.IndexByte(escapable, c) >= 0:
+		p.pos++
+		p.text(string(c), start)
+	default:
+		p.text(`\`, start)
+	}
+	return true
+}
+
+func (p *inlineParser) parseBackticks() bool {
+	start := p.pos
+	ticks := backtickRun.FindString(p.subject[p.pos:])
+	p.pos += len(ticks)
+	after := p.pos
+
+	if p.backticks == nil {
+		p.backticks = map[int][]int{}
+		for _, loc := range backtickRun.FindAllStringIndex(p.subject, -1) {
+			p.backticks[loc[1]-loc[0]] = append(p.backticks[loc[1]-loc[0]], loc[0])
+		}
+	}
+	runs := p.backticks[len(ticks)]
+	if i := sort.SearchInts(runs, after); i < len(runs) {
+		closing := runs[i]
+		content := strings.ReplaceAll(p.subject[after:closing], "\n", " ")
+		if p.block.Kind == TableCellNode {
+			content = strings.ReplaceAll(content, `\|`, "|") // escaped pipes are part of the table syntax
+		}
+		if strings.Trim(content, " ") != "" && content[0] == ' ' && content[len(content)-1] == ' ' {
+			content = content[1 : len(content)-1]
+		}
+		p.pos = closing + len(ticks)
+		node := newNode(CodeSpanNode)
+		node.Literal = content
+		p.add(node, start)
+		return true
+	}
+
+	// no closing run, the ticks are just text
+	p.text(ticks, start)
+	return true
+}
+
+func (p *inlineParser) parseEntity() bool {
+	match := entity.FindString(p.subject[p.pos:])
+	if match == "" {
+		return false
+	}
+	start := p.pos
+	p.pos += len(match)
+	p.text(html.UnescapeString(match), start)
+	return true
+}
+
+func (p *inlineParser) parseAutolink() bool {
+	rest := p.subject[p.pos:]
+	match, destination := emailAutolink.FindString(rest), ""
+	if match != "" {
+		destination = "mailto:" + match[1:len(match)-1]
+	} else if match = uriAutolink.FindString(rest); match != "" {
+		destination = match[1 : len(match)-1]
+	} else {
+		return false
+	}
+
+	start := p.pos
+	p.pos += len(match)
+	link := p.add(newNode(LinkNode), start)
+	link.Destination = destination
+
+	text := newNode(TextNode)
+	text.Literal = match[1 : len(match)-1]
+	text.start, text.end = start+1, p.pos-1
+	link.appendInline(text)
+	return true
+}
+
+func (p *inlineParser) parseHTMLTag() bool {
+	match := htmlTag.FindString(p.subject[p.pos:])
+	if match == "" {
+		return false
+	}
+	start := p.pos
+	p.pos += len(match)
+	node := newNode(HTMLInlineNode)
+	node.Literal = match
+	p.add(node, start)
+	return true
+}
+
+// emphasis
+
+func (p *inlineParser) handleDelim(c byte) bool {
+	start := p.pos
+	count, canOpen, canClose := p.scanDelims(c)
+	p.pos += count
+	node := p.text(p.subject[start:p.pos], start)
+
+	// strikethrough is one or two tildes, more than that is just text
+	if c == '~' && count > 2 || !canOpen && !canClose {
+		return true
+	}
+	d := &delimiter{char: c, count: count, origCount: count, node: node, previous: p.delimiters, canOpen: canOpen, canClose: canClose}
+	if d.previous != nil {
+		d.previous.next = d
+	}
+	p.delimiters = d
+	return true
+}
+
+// scanDelims looks at the run of c at pos and works out if it can open or close emphasis, from
+// what is on either side of it
+func (p *inlineParser) scanDelims(c byte) (count int, canOpen, canClose bool) {
+	for p.pos+count < len(p.subject) && p.subject[p.pos+count] == c {
+		count++
+	}
+
+	before, after := '\n', '\n'
+	if p.pos > 0 {
+		before, _ = utf8.DecodeLastRuneInString(p.subject[:p.pos])
+	}
+	if p.pos+count < len(p.subject) {
+		after, _ = utf8.DecodeRuneInString(p.subject[p.pos+count:])
+	}
+
+	beforeSpace, afterSpace := unicode.IsSpace(before), unicode.IsSpace(after)
+	beforePunct, afterPunct := isPunctuation(before), isPunctuation(after)
+	leftFlanking := !afterSpace && (!afterPunct || beforeSpace || beforePunct)
+	rightFlanking := !beforeSpace && (!beforePunct || afterSpace || afterPunct)
+
+	if c == '_' {
+		return count, leftFlanking && (!rightFlanking || beforePunct), rightFlanking && (!leftFlanking || afterPunct)
+	}
+	return count, leftFlanking, rightFlanking
+}
+
+func isPunctuation(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func (p *inlineParser) removeDelimiter(d *delimiter) {
+	if d.previous != nil {
+		d.previous.next = d.next
+	}
+	if d.next != nil {
+		d.next.previous = d.previous
+	} else {
+		p.delimiters = d.previous
+	}
+}
+
+// processEmphasis pairs up the delimiters above stackBottom into emphasis, strong emphasis and
+// strikethrough, this is the "process emphasis" procedure from the spec
+func (p *inlineParser) processEmphasis(stackBottom *delimiter) {
+	type bottomKey struct {
+		char    byte
+		canOpen bool
+		mod     int
+	}
+	openersBottom := map[bottomKey]*delimiter{}
+
+	closer := p.delimiters
+	for closer != nil && closer.previous != stackBottom {
+		closer = closer.previous
+	}
+
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+
+		key := bottomKey{closer.char, closer.canOpen, closer.origCount % 3}
+		bottom, ok := openersBottom[key]
+		if !ok {
+			bottom = stackBottom
+		}
+		opener := closer.previous
+		found := false
+		for opener != nil && opener != stackBottom && opener != bottom {
+			oddMatch := (closer.canOpen || opener.canClose) && closer.origCount%3 != 0 &&
+				(opener.origCount+closer.origCount)%3 == 0
+			sameLength := closer.char != '~' || opener.count == closer.count
+			if opener.char == closer.char && opener.canOpen && !oddMatch && sameLength {
+				found = true
+				break
+			}
+			opener = opener.previous
+		}
+
+		if !found {
+			openersBottom[key] = closer.previous
+			next := closer.next
+			if !closer.canOpen {
+				p.removeDelimiter(closer)
+			}
+			closer = next
+			continue
+		}
+
+		use, kind := 1, EmphasisNode
+		switch {
+		case closer.char == '~':
+			use, kind = closer.count, StrikethroughNode
+		case closer.count >= 2 && opener.count >= 2:
+			use, kind = 2, StrongNode
+		}
+		openerNode, closerNode := opener.node, closer.node
+		opener.count -= use
+		closer.count -= use
+		openerNode.Literal = openerNode.Literal[:len(openerNode.Literal)-use]
+		openerNode.end -= use
+		closerNode.Literal = closerNode.Literal[:len(closerNode.Literal)-use]
+		closerNode.start += use
+
+		// everything between the markers goes into the new node
+		emphasis := newNode(kind)
+		emphasis.start, emphasis.end = openerNode.end, closerNode.start
+		if openerNode.next != closerNode {
+			emphasis.adoptInlines(openerNode.next, closerNode.previous)
+		}
+		openerNode.insertInlineAfter(emphasis)
+
+		// the delimiters in between can't match anything anymore
+		if opener.next != closer {
+			opener.next = closer
+			closer.previous = opener
+		}
+
+		if opener.count == 0 {
+			openerNode.unlinkInline()
+			p.removeDelimiter(opener)
+		}
+		if closer.count == 0 {
+			closerNode.unlinkInline()
+			next := closer.next
+			p.removeDelimiter(closer)
+			closer = next
+		}
+	}
+
+	for p.delimiters != nil && p.delimiters != stackBottom {
+		p.removeDelimiter(p.delimiters)
+	}
+}
+
+// links
+
+func (p *inlineParser) addBracket(node *Node, index int, image bool) {
+	if p.brackets != nil {
+		p.brackets.bracketAfter = true
+	}
+	p.brackets = &bracket{node: node, previous: p.brackets, previousDelimiter: p.delimiters, index: index, image: image, links: p.links}
+}
+
+func (p *inlineParser) parseOpenBracket() bool {
+	start := p.pos
+	p.pos++
+	p.addBracket(p.text("[", start), start, false)
+	return true
+}
+
+func (p *inlineParser) parseBang() bool {
+	start := p.pos
+	p.pos++
+	if p.peek() != '[' {
+		p.text("!", start)
+		return true
+	}
+	p.pos++
+	p.addBracket(p.text("![", start), start+1, true)
+	return true
+}
+
+func (p *inlineParser) parseCloseBracket() bool {
+	start := p.pos
+	p.pos++
+
+	opener := p.brackets
+	if opener == nil {
+		p.text("]", start)
+		return true
+	}
+	// links can't contain other links, a [ from before one can't make a link anymore
+	if !opener.image && opener.links != p.links {
+		p.text("]", start)
+		p.brackets = opener.previous
+		return true
+	}
+
+	var destination, title, label string
+	matched := false
+	after := p.pos
+
+	// an inline link, [text](destination "title")
+	if p.peek() == '(' {
+		p.pos++
+		p.spnl()
+		if dest, ok := p.parseLinkDestination(); ok {
+			destination = dest
+			p.spnl()
+			if p.pos > 0 && isSpaceOrNewline(p.subject[p.pos-1]) {
+				title, _ = p.parseLinkTitle()
+			}
+			p.spnl()
+			if p.peek() == ')' {
+				p.pos++
+				matched = true
+			}
+		}
+		if !matched {
+			p.pos = after
+		}
+	}
+
+	// a reference link, [text][label], [text][] or [text]
+	if !matched {
+		beforeLabel := p.pos
+		n := p.parseLinkLabel()
+		switch {
+		case n > 2:
+			label = p.subject[beforeLabel+1 : beforeLabel+n-1]
+		case !opener.bracketAfter:
+			label = p.subject[opener.index+1 : start]
+		}
+		if n == 0 {
+			p.pos = after
+		}
+		if label != "" {
+			if definition := p.ast.Definitions[NormalizeLabel(label)]; definition != nil {
+				destination, title = definition.Destination, definition.Title
+				matched = true
+			}
+		}
+	}
+
+	if !matched {
+		if !opener.image && p.parseFootnoteReference(opener, start) {
+			return true
+		}
+		p.brackets = opener.previous
+		p.pos = after
+		p.text("]", start)
+		return true
+	}
+
+	kind := LinkNode
+	nodeStart := opener.index
+	if opener.image {
+		kind, nodeStart = ImageNode, opener.index-1
+	}
+	link := newNode(kind)
+	link.Destination, link.Title, link.Label = destination, title, label
+	p.wrap(link, opener.node, nodeStart)
+	p.processEmphasis(opener.previousDelimiter)
+	p.brackets = opener.previous
+
+	if !opener.image {
+		p.links++
+	}
+	return true
+}
+
+// parseFootnoteReference makes [^label] a footnote reference when there is a footnote for it
+func (p *inlineParser) parseFootnoteReference(opener *bracket, closeBracket int) bool {
+	label := p.subject[opener.index+1 : closeBracket]
+	if !strings.HasPrefix(label, "^") || strings.ContainsAny(label, " \t\n") || p.ast.Footnotes[NormalizeLabel(label[1:])] == nil {
+		return false
+	}
+	p.pos = closeBracket + 1
+	reference := newNode(FootnoteReferenceNode)
+	reference.Label = label[1:]
+	p.wrap(reference, opener.node, opener.index)
+	reference.firstInline, reference.lastInline = nil, nil // the label is all there is to it
+
+	for p.delimiters != opener.previousDelimiter {
+		p.removeDelimiter(p.delimiters)
+	}
+	p.brackets = opener.previous
+	return true
+}
+
+// wrap replaces the bracket node and everything after it with node, which gets what came after
+// the bracket as its children
+func (p *inlineParser) wrap(node, bracketNode *Node, start int) {
+	if bracketNode.next != nil {
+		node.adoptInlines(bracketNode.next, p.block.lastInline)
+	}
+	bracketNode.unlinkInline()
+	p.add(node, start)
+}
+
+func isSpaceOrNewline(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// spnl skips spaces and at most one newline
+func (p *inlineParser) spnl() {
+	for p.pos < len(p.subject) && isSpaceOrTab(p.subject[p.pos]) {
+		p.pos++
+	}
+	if p.peek() == '\n' {
+		p.pos++
+	}
+	for p.pos < len(p.subject) && isSpaceOrTab(p.subject[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *inlineParser) parseLinkLabel() int {
+	match := linkLabel.FindString(p.subject[p.pos:])
+	p.pos += len(match)
+	return len(match)
+}
+
+func (p *inlineParser) parseLinkTitle() (string, bool) {
+	match := linkTitle.FindString(p.subject[p.pos:])
+	if match == "" {
+		return "", false
+	}
+	p.pos += len(match)
+	return unescapeString(match[1 : len(match)-1]), true
+}
+
+// maxLinkParens is how deep parentheses can nest in a destination without <>, cmark's limit. an
+// unclosed ( would otherwise have every link after it look at the rest of the paragraph again
+const maxLinkParens = 32
+
+func (p *inlineParser) parseLinkDestination() (string, bool) {
+	rest := p.subject[p.pos:]
+	if strings.HasPrefix(rest, "<") {
+		for i := 1; i < len(rest); i++ {
+			switch rest[i] {
+			case '>':
+				p.pos += i + 1
+				return unescapeString(rest[1:i]), true
+			case '\\':
+				i++
+			case '\n', '<':
+				return "", false
+			}
+		}
+		return "", false
+	}
+
+	depth, i := 0, 0
+loop:
+	for i < len(rest) {
+		switch c := rest[i]; {
+		case c == '\\' && i+1 < len(rest) && strings.IndexByte(escapable, rest[i+1]) >= 0:
+			i += 2
+			continue
+		case c == '(':
+			depth++
+			if depth > maxLinkParens {
+				return "", false
+			}
+		case c == ')':
+			if depth == 0 {
+				break loop
+			}
+			depth--
+		case c <= ' ' || c == 0x7f:
+			break loop
+		}
+		i++
+	}
+	if depth != 0 || i == 0 && !strings.HasPrefix(rest, ")") {
+		return "", false
+	}
+	p.pos += i
+	return unescapeString(rest[:i]), true
+}
+
+// definitionLength is how much of text is link reference definitions
+func definitionLength(text string) int {
+	consumed := 0
+	for {
+		_, n := parseDefinition(text[consumed:])
+		if n == 0 {
+			return consumed
+		}
+		consumed += n
+	}
+}
+
+// parseDefinition reads one link reference definition off the start of text, n is 0 if there isn't one
+func parseDefinition(text string) (definition *Node, n int) {
+	p := &inlineParser{subject: text}
+	labelLength := p.parseLinkLabel()
+	if labelLength == 0 || p.peek() != ':' {
+		return nil, 0
+	}
+	label := text[1 : labelLength-1]
+	if strings.TrimSpace(label) == "" {
+		return nil, 0
+	}
+	p.pos++
+
+	p.spnl()
+	destination, ok := p.parseLinkDestination()
+	if !ok {
+		return nil, 0
+	}
+
+	beforeTitle := p.pos
+	p.spnl()
+	title := ""
+	if p.pos != beforeTitle {
+		if title, ok = p.parseLinkTitle(); !ok {
+			p.pos = beforeTitle
+		}
+	}
+
+	// nothing but spaces can follow on the line. if the title had something after it, the
+	// definition might still be fine without the title
+	atLineEnd := func() bool {
+		for p.pos < len(p.subject) && isSpaceOrTab(p.subject[p.pos]) {
+			p.pos++
+		}
+		return p.pos == len(p.subject) || p.subject[p.pos] == '\n'
+	}
+	if !atLineEnd() {
+		if title == "" {
+			return nil, 0
+		}
+		title = ""
+		p.pos = beforeTitle
+		if !atLineEnd() {
+			return nil, 0
+		}
+	}
+	if p.pos < len(p.subject) {
+		p.pos++ // the newline
+	}
+
+	definition = newNode(DefinitionNode)
+	definition.Label, definition.Destination, definition.Title = label, destination, title
+	return definition, p.pos
+}
+
+// mergeText joins text nodes that ended up next to each other, delimiters and brackets that
+// didn't match anything leave a lot of them behind
+func mergeText(node *Node) {
+	children := node.Children[:0]
+	for i := 0; i < len(node.Children); i++ {
+		child := node.Children[i]
+		if child.Kind == TextNode && i+1 < len(node.Children) && node.Children[i+1].Kind == TextNode {
+			// joined in one go, adding them one at a time copies the text over and over
+			var literal strings.Builder
+			for ; i < len(node.Children) && node.Children[i].Kind == TextNode; i++ {
+				literal.WriteString(node.Children[i].Literal)
+				child.end = node.Children[i].end
+			}
+			i--
+			child.Literal = literal.String()
+		}
+		mergeText(child)
+		children = append(children, child)
+	}
+	node.Children = children
+}
+
+func setRanges(node *Node, source *inlineSource) {
+	for _, child := range node.Children {
+		child.Range = lsp.Range{Start: source.position(child.start), End: source.position(child.end)}
+		setRanges(child, source)
+	}
+}
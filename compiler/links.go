@@ -0,0 +1,240 @@
+package compiler
+
+import (
+	"lsp/lsp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// links between documents. a destination like other.md#setup is a path relative to the document
+// the link is in, and an anchor for a heading in it made the way github makes them. documents the
+// editor doesn't have open get read from disk
+
+// markdownExtensions are the files we parse when a link points into them
+var markdownExtensions = []string{".md", ".markdown"}
+
+func isMarkdown(path string) bool {
+	for _, extension := range markdownExtensions {
+		if strings.EqualFold(filepath.Ext(path), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// uriPath is the file a file:// uri points at, ok is false for any other kind of uri
+func uriPath(uri string) (path string, ok bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return filepath.Clean(filepath.FromSlash(u.Path)), true
+}
+
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// SetWorkspaceFolders should be called from initialize with the folders the editor has open
+func (s *State) SetWorkspaceFolders(uris []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.folders = []string{}
+	for _, uri := range uris {
+		if path, ok := uriPath(uri); ok {
+			s.folders = append(s.folders, path)
+		}
+	}
+}
+
+// workspaceRoot is the workspace folder path is in, the deepest one if they are nested
+func (s *State) workspaceRoot(path string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	root := ""
+	for _, folder := range s.folders {
+		if inside(path, folder) && len(folder) > len(root) {
+			root = folder
+		}
+	}
+	return root, root != ""
+}
+
+// inside is true if path is folder or something below it
+func inside(path, folder string) bool {
+	relative, err := filepath.Rel(folder, path)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// diskDocument is a file we read, with what it looked like on disk at the time
+type diskDocument struct {
+	snapshot *Snapshot
+	modTime  time.Time
+	size     int64
+}
+
+// document is the snapshot of the markdown file at path, the open one if the editor has it open,
+// otherwise read from disk. files on disk only get read again once they change
+func (s *State) document(path string) (*Snapshot, bool) {
+	if snapshot, ok := s.openDocument(path); ok {
+		return snapshot, true
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		s.forgetDisk(path) // gone, or never was a file
+		return nil, false
+	}
+	s.mu.RLock()
+	encoding := s.encoding
+	s.mu.RUnlock()
+
+	s.diskMu.Lock()
+	cached, ok := s.disk[path]
+	s.diskMu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() && cached.snapshot.encoding == encoding {
+		return cached.snapshot, true
+	}
+
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	snapshot := NewSnapshot(fileURI(path), 0, string(text), encoding)
+
+	s.diskMu.Lock()
+	s.disk[path] = diskDocument{snapshot: snapshot, modTime: info.ModTime(), size: info.Size()}
+	s.diskMu.Unlock()
+	return snapshot, true
+}
+
+// forgetDisk drops what we read of the file at path, for when it is gone or the editor has it
+// open, which always wins over the disk
+func (s *State) forgetDisk(path string) {
+	s.diskMu.Lock()
+	delete(s.disk, path)
+	s.diskMu.Unlock()
+}
+
+// openDocument finds an open document by its path, the client's uri for it can be spelled
+// differently than ours (an escaped drive letter colon, say)
+func (s *State) openDocument(path string) (*Snapshot, bool) {
+	for _, snapshot := range s.Snapshots() {
+		if open, ok := uriPath(snapshot.URI); ok && open == path {
+			return snapshot, true
+		}
+	}
+	return nil, false
+}
+
+// destination is a link destination split into the file it points at and the anchor after #.
+// path is "" for links inside the same document
+type destination struct {
+	path   string
+	anchor string
+}
+
+// parseDestination takes a link destination apart. ok is false for ones that aren't files, like
+// urls and email addresses
+func parseDestination(raw string) (destination, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" {
+		return destination{}, false
+	}
+	return destination{path: u.Path, anchor: u.Fragment}, u.Path != "" || u.Fragment != ""
+}
+
+// resolvePaths is every file a destination path from the document at from could mean. other
+// finds other.md too, and a folder its README.md, so there can be more than one
+func (s *State) resolvePaths(from *Snapshot, path string) []string {
+	paths := []string{}
+	for _, candidate := range s.candidatePaths(from, path) {
+		if _, open := s.openDocument(candidate); open {
+			paths = append(paths, candidate)
+		} else if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			paths = append(paths, candidate)
+		}
+	}
+	return paths
+}
+
+// candidatePaths is what resolvePaths tries, whether the files are there or not
+func (s *State) candidatePaths(from *Snapshot, path string) []string {
+	var base string
+	if strings.HasPrefix(path, "/") {
+		// from the top of the workspace, like github does from the top of the repository. without a
+		// workspace folder there is no top, and the root of the filesystem is not it
+		fromPath, _ := uriPath(from.URI)
+		root, ok := s.workspaceRoot(fromPath)
+		if !ok {
+			return nil
+		}
+		base = root
+	} else if fromPath, ok := uriPath(from.URI); ok {
+		base = filepath.Dir(fromPath)
+	} else {
+		return nil // an untitled document isn't anywhere
+	}
+	resolved := filepath.Join(base, filepath.FromSlash(path))
+
+	candidates := []string{resolved}
+	if filepath.Ext(resolved) == "" {
+		for _, extension := range markdownExtensions {
+			candidates = append(candidates, resolved+extension)
+		}
+	}
+	return append(candidates, filepath.Join(resolved, "README.md"), filepath.Join(resolved, "index.md"))
+}
+
+// Slug is the anchor github gives a heading with this text: lower case, punctuation dropped and
+// spaces turned into dashes
+func Slug(text string) string {
+	var slug strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r == ' ':
+			slug.WriteByte('-')
+		case r == '-' || unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r) || unicode.Is(unicode.Pc, r):
+			slug.WriteRune(r)
+		}
+	}
+	return slug.String()
+}
+
+// Anchors is every heading in the document by its anchor. a heading with the same text as one
+// before it gets -1, -2 and so on after its slug. one with nothing left in its slug, like a heading
+// that is only an emoji, has no anchor, but the next one like it still gets -1
+func (a *AST) Anchors() map[string]*Node {
+	anchors, used := map[string]*Node{}, map[string]bool{}
+	for _, heading := range a.Find(HeadingNode) {
+		slug := Slug(heading.Text())
+		anchor := slug
+		for n := 1; used[anchor]; n++ {
+			anchor = slug + "-" + strconv.Itoa(n)
+		}
+		used[anchor] = true
+		if anchor != "" {
+			anchors[anchor] = heading
+		}
+	}
+	return anchors
+}
+
+// Section is the heading and everything under it, up to the next heading that isn't below it
+func (a *AST) Section(heading *Node) lsp.Range {
+	section := lsp.Range{Start: heading.Range.Start, End: a.Root.Range.End}
+	for _, next := range a.Find(HeadingNode) {
+		if positionBefore(heading.Range.Start, next.Range.Start) && next.Level <= heading.Level {
+			section.End = next.Range.Start
+			break
+		}
+	}
+	return section
+}
@@ -0,0 +1,158 @@
+package compiler_test
+
+import (
+	"lsp/compiler"
+	"lsp/lsp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSlug(t *testing.T) {
+	tests := map[string]string{
+		"Getting Started":       "getting-started",
+		"What's new in v2.0?":   "whats-new-in-v20",
+		"snake_case and-dashes": "snake_case-and-dashes",
+		"Ünïcode  headings":     "ünïcode--headings",
+	}
+	for text, expected := range tests {
+		if got := compiler.Slug(text); got != expected {
+			t.Errorf("Expected: %s for %q, Got: %s", expected, text, got)
+		}
+	}
+
+	anchors := compiler.Parse("# Setup\n\n## Setup\n\n## Setup\n\n## 🎉\n\n## 🎉").Anchors()
+	for _, anchor := range []string{"setup", "setup-1", "setup-2", "-1"} {
+		if anchors[anchor] == nil {
+			t.Errorf("Expected: a heading for #%s, Got: %v", anchor, anchors)
+		}
+	}
+	if anchors[""] != nil {
+		t.Errorf("Expected: no anchor for a heading with an empty slug, Got: %v", anchors[""])
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func uri(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func TestDefinitionAcrossFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"docs/other.md":        "# Other\n\n## Install Steps\n\nrun it\n\n## Next\n",
+		"docs/logo.png":        "not really a png",
+		"docs/guide.md":        "# Guide\n",
+		"docs/guide/README.md": "# Guide folder\n",
+	})
+	state := compiler.NewState()
+	state.SetWorkspaceFolders([]string{uri(dir)})
+	snapshot := state.OpenDocument(uri(filepath.Join(dir, "docs/a.md")), 1,
+		"[install](other.md#install-steps) ![logo](logo.png) [guide](guide)\n"+
+			"[top](/docs/other.md) [missing](nope.md) [web](https://x.y) [here](#local)\n\n"+
+			"# Local\n\n[x][dup]\n\n[dup]: other.md\n[dup]: logo.png\n")
+
+	tests := []struct {
+		name     string
+		position lsp.Position
+		expected []lsp.LocationLink
+	}{
+		{"a heading in another file", lsp.Position{Line: 0, Character: 3}, []lsp.LocationLink{{
+			TargetURI:            uri(filepath.Join(dir, "docs/other.md")),
+			TargetRange:          lsp.Range{Start: lsp.Position{Line: 2, Character: 0}, End: lsp.Position{Line: 6, Character: 0}},
+			TargetSelectionRange: compiler.LineRange(2, 0, 16),
+		}}},
+		{"an image", lsp.Position{Line: 0, Character: 37}, []lsp.LocationLink{{
+			TargetURI: uri(filepath.Join(dir, "docs/logo.png")),
+		}}},
+		{"a path that could be two files", lsp.Position{Line: 0, Character: 55}, []lsp.LocationLink{
+			{TargetURI: uri(filepath.Join(dir, "docs/guide.md"))},
+			{TargetURI: uri(filepath.Join(dir, "docs/guide/README.md"))},
+		}},
+		{"from the top of the workspace", lsp.Position{Line: 1, Character: 2}, []lsp.LocationLink{{
+			TargetURI: uri(filepath.Join(dir, "docs/other.md")),
+		}}},
+		{"a file that isn't there", lsp.Position{Line: 1, Character: 25}, nil},
+		{"a url", lsp.Position{Line: 1, Character: 44}, nil},
+		{"a heading in the same file", lsp.Position{Line: 1, Character: 65}, []lsp.LocationLink{{
+			TargetURI:            snapshot.URI,
+			TargetRange:          lsp.Range{Start: lsp.Position{Line: 3, Character: 0}, End: lsp.Position{Line: 9, Character: 0}},
+			TargetSelectionRange: compiler.LineRange(3, 0, 7),
+		}}},
+		{"a label defined twice", lsp.Position{Line: 5, Character: 1}, []lsp.LocationLink{
+			{TargetURI: snapshot.URI, TargetRange: compiler.LineRange(7, 0, 15), TargetSelectionRange: compiler.LineRange(7, 0, 15)},
+			{TargetURI: snapshot.URI, TargetRange: compiler.LineRange(8, 0, 15), TargetSelectionRange: compiler.LineRange(8, 0, 15)},
+		}},
+		{"a definition goes where it points", lsp.Position{Line: 8, Character: 10}, []lsp.LocationLink{{
+			TargetURI: uri(filepath.Join(dir, "docs/logo.png")),
+		}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			links := state.Definition(snapshot, test.position)
+			if len(links) != len(test.expected) {
+				t.Fatalf("Expected: %d targets, Got: %+v", len(test.expected), links)
+			}
+			for i, link := range links {
+				if link.OriginSelectionRange == nil {
+					t.Fatalf("Expected: an origin selection range, Got: none")
+				}
+				link.OriginSelectionRange = nil
+				if link != test.expected[i] {
+					t.Errorf("Expected: %+v, Got: %+v", test.expected[i], link)
+				}
+			}
+		})
+	}
+}
+
+func TestDefinitionPrefersOpenDocuments(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"other.md": "# Old\n"})
+	state := compiler.NewState()
+	state.OpenDocument(uri(filepath.Join(dir, "other.md")), 1, "intro\n\n# New\n")
+	snapshot := state.OpenDocument(uri(filepath.Join(dir, "a.md")), 1, "[new](other.md#new)\n")
+
+	links := state.Definition(snapshot, lsp.Position{Line: 0, Character: 1})
+	if len(links) != 1 || links[0].TargetSelectionRange != compiler.LineRange(2, 0, 5) {
+		t.Fatalf("Expected: the heading in the unsaved buffer, Got: %+v", links)
+	}
+	if *links[0].OriginSelectionRange != compiler.LineRange(0, 0, 19) {
+		t.Fatalf("Expected: the whole link as the origin, Got: %v", *links[0].OriginSelectionRange)
+	}
+}
+
+func TestDefinitionOfAFileWithAnEmptySlugHeading(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"other.md": "## 🎉\n\nparty\n"})
+	state := compiler.NewState()
+	snapshot := state.OpenDocument(uri(filepath.Join(dir, "a.md")), 1, "[x](other.md)\n")
+
+	links := state.Definition(snapshot, lsp.Position{Line: 0, Character: 1})
+	expected := lsp.LocationLink{TargetURI: uri(filepath.Join(dir, "other.md"))}
+	if len(links) != 1 || links[0].TargetURI != expected.TargetURI || links[0].TargetSelectionRange != expected.TargetSelectionRange {
+		t.Fatalf("Expected: the file, not its first heading, Got: %+v", links)
+	}
+}
+
+func TestDefinitionFromTheTopWithoutAWorkspace(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"other.md": "# Other\n"})
+	state := compiler.NewState()
+	snapshot := state.OpenDocument(uri(filepath.Join(dir, "a.md")), 1, "[x]("+filepath.ToSlash(filepath.Join(dir, "other.md"))+")\n")
+
+	if links := state.Definition(snapshot, lsp.Position{Line: 0, Character: 1}); len(links) != 0 {
+		t.Fatalf("Expected: nothing without a workspace folder, Got: %+v", links)
+	}
+}
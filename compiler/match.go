@@ -0,0 +1,78 @@
+package compiler
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MatchOptions change how a Matcher compares text
+type MatchOptions struct {
+	IgnoreCase bool
+	// only matches that aren't glued to a letter, digit or _ on either side
+	WholeWord bool
+}
+
+// Span is a match in a line, byte offsets with End exclusive
+type Span struct {
+	Start, End int
+}
+
+// Matcher finds every occurrence of a phrase or regex in a line, rules should use it instead
+// of strings.Index so nothing after the first hit gets missed
+type Matcher struct {
+	pattern   *regexp.Regexp
+	wholeWord bool
+}
+
+// NewMatcher matches phrase literally
+func NewMatcher(phrase string, options MatchOptions) *Matcher {
+	return newMatcher(regexp.QuoteMeta(phrase), options)
+}
+
+// NewRegexMatcher matches a regular expression in go's syntax
+func NewRegexMatcher(expr string, options MatchOptions) (*Matcher, error) {
+	if options.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Matcher{pattern: pattern, wholeWord: options.WholeWord}, nil
+}
+
+func newMatcher(expr string, options MatchOptions) *Matcher {
+	matcher, err := NewRegexMatcher(expr, options)
+	if err != nil {
+		panic(err) // quoted, can't happen
+	}
+	return matcher
+}
+
+// FindAll returns every match in line, left to right and not overlapping. empty matches are skipped
+func (m *Matcher) FindAll(line string) []Span {
+	spans := []Span{}
+	for _, loc := range m.pattern.FindAllStringIndex(line, -1) {
+		start, end := loc[0], loc[1]
+		if end > start && (!m.wholeWord || isWordBoundary(line, start, end)) {
+			spans = append(spans, Span{Start: start, End: end})
+		}
+	}
+	return spans
+}
+
+// Matches reports whether there is at least one match in line
+func (m *Matcher) Matches(line string) bool {
+	return len(m.FindAll(line)) > 0
+}
+
+func isWordBoundary(line string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(line[:start])
+	after, _ := utf8.DecodeRuneInString(line[end:])
+	return (start == 0 || !isWordRune(before)) && (end == len(line) || !isWordRune(after))
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,64 @@
+package compiler_test
+
+import (
+	"lsp/compiler"
+	"lsp/lsp"
+	"reflect"
+	"testing"
+)
+
+func TestMatcherFindAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		matcher  *compiler.Matcher
+		line     string
+		expected []compiler.Span
+	}{
+		{"every occurrence", compiler.NewMatcher("VS Code", compiler.MatchOptions{}), "VS Code or VS Code", []compiler.Span{{0, 7}, {11, 18}}},
+		{"case sensitive", compiler.NewMatcher("neovim", compiler.MatchOptions{}), "Neovim", []compiler.Span{}},
+		{"ignore case", compiler.NewMatcher("neovim", compiler.MatchOptions{IgnoreCase: true}), "Neovim NEOVIM", []compiler.Span{{0, 6}, {7, 13}}},
+		{"whole word", compiler.NewMatcher("cool", compiler.MatchOptions{WholeWord: true}), "coolant, cool_beans, cool.", []compiler.Span{{21, 25}}},
+		{"unicode letters count as word", compiler.NewMatcher("café", compiler.MatchOptions{WholeWord: true}), "cafés café", []compiler.Span{{7, 12}}},
+		{"literal", compiler.NewMatcher("a.b", compiler.MatchOptions{}), "axb a.b", []compiler.Span{{4, 7}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.matcher.FindAll(test.line); !reflect.DeepEqual(got, test.expected) {
+				t.Fatalf("Expected: %v, Got: %v", test.expected, got)
+			}
+		})
+	}
+
+	if _, err := compiler.NewRegexMatcher("(", compiler.MatchOptions{}); err == nil {
+		t.Fatal("Expected: an error for a bad regex")
+	}
+}
+
+func TestEveryOccurrenceIsReported(t *testing.T) {
+	state := compiler.NewState()
+	snapshot := state.OpenDocument("file:///a.md", 1, "VS Code, VS Code\nnot really. yep. this is it\n")
+
+	ranges := map[string][]lsp.Range{}
+	for _, diagnostic := range state.Diagnostics(snapshot) {
+		ranges[diagnostic.Source] = append(ranges[diagnostic.Source], diagnostic.Range)
+	}
+
+	if expected := []lsp.Range{compiler.LineRange(0, 0, 7), compiler.LineRange(0, 9, 16)}; !reflect.DeepEqual(ranges["Common Sense"], expected) {
+		t.Fatalf("Expected: %v, Got: %v", expected, ranges["Common Sense"])
+	}
+	// the second phrase gets its own length
+	if expected := []lsp.Range{compiler.LineRange(1, 0, 10), compiler.LineRange(1, 12, 27)}; !reflect.DeepEqual(ranges["VibeCheck"], expected) {
+		t.Fatalf("Expected: %v, Got: %v", expected, ranges["VibeCheck"])
+	}
+
+	fixes := 0
+	for _, action := range state.TextDocumentCodeAction(snapshot, nil) {
+		if action.Title == "Replace VS C*de with a superior editor" {
+			fixes++
+		}
+	}
+	if fixes != 2 {
+		t.Fatalf("Expected: a fix for both VS Codes, Got: %d", fixes)
+	}
+}
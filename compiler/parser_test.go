@@ -0,0 +1,253 @@
+package compiler_test
+
+import (
+	"fmt"
+	"lsp/compiler"
+	"lsp/lsp"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tree writes the tree out the way the commonmark test suite's xml does, minus the xml
+func tree(node *compiler.Node) string {
+	var out strings.Builder
+	var walk func(node *compiler.Node, depth int)
+	walk = func(node *compiler.Node, depth int) {
+		fmt.Fprintf(&out, "%s%s", strings.Repeat("  ", depth), node.Kind)
+		switch node.Kind {
+		case compiler.HeadingNode:
+			fmt.Fprintf(&out, " %d", node.Level)
+		case compiler.ListNode:
+			fmt.Fprintf(&out, " tight=%v", node.Tight)
+		case compiler.ItemNode:
+			if node.Task {
+				fmt.Fprintf(&out, " checked=%v", node.Checked)
+			}
+		case compiler.LinkNode, compiler.ImageNode, compiler.DefinitionNode:
+			fmt.Fprintf(&out, " %s", node.Destination)
+		case compiler.CodeBlockNode:
+			fmt.Fprintf(&out, " %q", node.Info)
+		}
+		if node.Literal != "" {
+			fmt.Fprintf(&out, " %q", node.Literal)
+		}
+		out.WriteByte('\n')
+		for _, child := range node.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(node, 0)
+	return out.String()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{"a heading in a code block is code", "# title\n```md\n# not a title\n```\n", `
+document
+  heading 1
+    text "title"
+  code_block "md" "# not a title\n"
+`},
+		{"emphasis", "*a **b** c* ~~d~~ `e`", `
+document
+  paragraph
+    emph
+      text "a "
+      strong
+        text "b"
+      text " c"
+    text " "
+    strikethrough
+      text "d"
+    text " "
+    code "e"
+`},
+		{"links", "[a](/x) [b][ref] ![c][]\n\n[ref]: /y\n[c]: /z.png", `
+document
+  paragraph
+    link /x
+      text "a"
+    text " "
+    link /y
+      text "b"
+    text " "
+    image /z.png
+      text "c"
+  definition /y
+  definition /z.png
+`},
+		{"loose and tight lists", "- [x] a\n- [ ] b\n\n1. c\n\n2. d", `
+document
+  list tight=true
+    item checked=true
+      paragraph
+        text "a"
+    item checked=false
+      paragraph
+        text "b"
+  list tight=false
+    item
+      paragraph
+        text "c"
+    item
+      paragraph
+        text "d"
+`},
+		{"table", "| a | b |\n| - | :-: |\n| c \\| d |", `
+document
+  table
+    table_row
+      table_cell
+        text "a"
+      table_cell
+        text "b"
+    table_row
+      table_cell
+        text "c | d"
+      table_cell
+`},
+		{"footnote", "hi[^1]\n\n[^1]: there", `
+document
+  paragraph
+    text "hi"
+    footnote_reference
+  footnote_definition
+    paragraph
+      text "there"
+`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := strings.TrimPrefix(test.expected, "\n")
+			if got := tree(compiler.Parse(test.markdown).Root); got != expected {
+				t.Fatalf("Expected:\n%s\nGot:\n%s", expected, got)
+			}
+		})
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	ast := compiler.Parse("# title\n\n> see [the *docs*](https://x.y)\n> for more\n\n    code\n\n")
+
+	tests := []struct {
+		kind     compiler.NodeKind
+		expected lsp.Range
+	}{
+		{compiler.HeadingNode, compiler.LineRange(0, 0, 7)},
+		{compiler.BlockQuoteNode, lsp.Range{Start: lsp.Position{Line: 2, Character: 0}, End: lsp.Position{Line: 3, Character: 10}}},
+		{compiler.LinkNode, compiler.LineRange(2, 6, 31)},
+		{compiler.EmphasisNode, compiler.LineRange(2, 11, 17)},
+		{compiler.SoftBreakNode, lsp.Range{Start: lsp.Position{Line: 2, Character: 31}, End: lsp.Position{Line: 3, Character: 2}}},
+		{compiler.CodeBlockNode, compiler.LineRange(5, 4, 8)},
+	}
+
+	for _, test := range tests {
+		nodes := ast.Find(test.kind)
+		if len(nodes) != 1 {
+			t.Fatalf("Expected: one %s, Got: %d", test.kind, len(nodes))
+		}
+		if nodes[0].Range != test.expected {
+			t.Errorf("Expected: %s at %v, Got: %v", test.kind, test.expected, nodes[0].Range)
+		}
+	}
+
+	if node := ast.NodeAt(lsp.Position{Line: 2, Character: 13}); node.Kind != compiler.TextNode || node.Parent.Kind != compiler.EmphasisNode {
+		t.Fatalf("Expected: the text in the emphasis, Got: %s", node.Kind)
+	}
+}
+
+// inputs that are easy to make quadratic, some of these took seconds at 30kb when they were. the
+// race detector slows everything down a lot, so rather than a fixed time this checks that parsing
+// something 16 times as long takes about as long as parsing the short one 16 times
+func TestParsePathological(t *testing.T) {
+	repeat := func(text string) func(n int) string {
+		return func(n int) string { return strings.Repeat(text, n) }
+	}
+	tests := map[string]struct {
+		input func(n int) string
+		n     int // how long the long one is
+	}{
+		"code span openers without closers": {repeat("`a``"), 4000},
+		"emphasis that keeps closing":       {repeat("a**"), 4000},
+		"a very long paragraph":             {repeat("a\n"), 20000},
+		"brackets that never close":         {repeat("["), 64000},
+		"text that keeps getting merged":    {repeat("_a "), 8000},
+		"a wide table with short rows": {func(n int) string {
+			return strings.Repeat("|a", n) + "|\n" + strings.Repeat("|-", n) + "|\n" + strings.Repeat("a\n", n)
+		}, 1600},
+		"links that never close":                   {repeat("[a]("), 4000},
+		"links with destinations that never close": {repeat("[a](b"), 4000},
+		"links after open brackets": {func(n int) string {
+			return strings.Repeat("![", n) + strings.Repeat("[a](b)", n)
+		}, 4000},
+	}
+	// the best of a few runs, so a garbage collection doesn't count
+	parseTime := func(text string, times int) time.Duration {
+		best := time.Duration(math.MaxInt64)
+		for range 3 {
+			start := time.Now()
+			for range times {
+				compiler.Parse(text)
+			}
+			best = min(best, time.Since(start))
+		}
+		return best
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			short := parseTime(test.input(test.n/16), 16)
+			long := parseTime(test.input(test.n), 1)
+			if long > 4*short {
+				t.Fatalf("Expected: about as long as the short one 16 times (%v), Got: %v", short, long)
+			}
+		})
+	}
+}
+
+func TestRulesSkipCode(t *testing.T) {
+	state := compiler.NewState()
+	snapshot := state.OpenDocument("file:///a.md", 1, "VS Code\n\n`VS Code`\n\n```\nVS Code\n# this is a test\n```\n\n<div>VS Code</div>\n\n# this is a test\n")
+
+	flagged := []int{}
+	for _, diagnostic := range state.Diagnostics(snapshot) {
+		flagged = append(flagged, diagnostic.Range.Start.Line)
+	}
+	if fmt.Sprint(flagged) != "[0 11]" {
+		t.Fatalf("Expected: only the prose on lines 0 and 11 flagged, Got: %v", flagged)
+	}
+}
+
+func TestHoverAndDefinition(t *testing.T) {
+	state := compiler.NewState()
+	snapshot := state.OpenDocument("file:///a.md", 1, "# intro\n\nsee [the docs][docs] and a note[^1]\n\n[docs]: https://x.y \"Docs\"\n[^1]: the note\n")
+
+	hover := state.Hover(snapshot, lsp.Position{Line: 2, Character: 6}, nil)
+	if hover.Contents.Value != "Link: https://x.y from [docs]\n\nDocs" || hover.Range == nil || *hover.Range != compiler.LineRange(2, 4, 20) {
+		t.Fatalf("Expected: a hover for the link, Got: %+v", hover)
+	}
+
+	tests := []struct {
+		position lsp.Position
+		expected lsp.Range
+	}{
+		{lsp.Position{Line: 2, Character: 6}, compiler.LineRange(4, 0, 26)},  // the reference link
+		{lsp.Position{Line: 2, Character: 34}, compiler.LineRange(5, 0, 14)}, // the footnote
+	}
+	for _, test := range tests {
+		links := state.Definition(snapshot, test.position)
+		if len(links) != 1 || links[0].TargetSelectionRange != test.expected {
+			t.Errorf("Expected: definition at %v for %v, Got: %+v", test.expected, test.position, links)
+		}
+	}
+
+	if links := state.Definition(snapshot, lsp.Position{Line: 2, Character: 0}); len(links) != 0 {
+		t.Errorf("Expected: no definition for plain text, Got: %+v", links)
+	}
+}
@@ -0,0 +1,130 @@
+package compiler
+
+import (
+	"lsp/lsp"
+	"unicode/utf8"
+)
+
+// everything in the compiler works with byte offsets into a line because that is what
+// strings.Index gives us. the client counts characters in whatever encoding we agreed on in
+// initialize (utf-16 unless we both said otherwise), so every position going out or coming in
+// goes through the conversions here
+
+// NegotiateEncoding picks the encoding we'll use from the ones the client offered. utf-8 is free
+// for us since it is just bytes, utf-32 is next best, and utf-16 is what everybody has to support
+func NegotiateEncoding(offered []lsp.PositionEncodingKind) lsp.PositionEncodingKind {
+	for _, preferred := range []lsp.PositionEncodingKind{lsp.UTF8, lsp.UTF32} {
+		for _, kind := range offered {
+			if kind == preferred {
+				return preferred
+			}
+		}
+	}
+	return lsp.UTF16
+}
+
+// how many code units a rune takes up in the given encoding
+func runeUnits(r rune, encoding lsp.PositionEncodingKind) int {
+	switch encoding {
+	case lsp.UTF8:
+		return utf8.RuneLen(r)
+	case lsp.UTF32:
+		return 1
+	}
+	if r >= 0x10000 { // outside the basic plane means a surrogate pair
+		return 2
+	}
+	return 1
+}
+
+// byteToCharacter turns a byte offset in line into a character offset in the given encoding
+func byteToCharacter(line string, offset int, encoding lsp.PositionEncodingKind) int {
+	if encoding == lsp.UTF8 {
+		return min(offset, len(line))
+	}
+
+	character := 0
+	for i := 0; i < offset && i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if r == utf8.RuneError && size == 1 {
+			character++ // invalid bytes count as one unit each, same as the replacement character
+		} else {
+			character += runeUnits(r, encoding)
+		}
+		i += size
+	}
+	return character
+}
+
+// characterToByte is the other way around, a character offset in the given encoding to a byte
+// offset in line. anything past the end of the line lands on the end, like the spec asks.
+// a position in the middle of a rune gets moved to the start of that rune
+func characterToByte(line string, character int, encoding lsp.PositionEncodingKind) int {
+	if character <= 0 {
+		return 0
+	}
+	if encoding == lsp.UTF8 {
+		return min(character, len(line))
+	}
+
+	units := 0
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		next := 1
+		if r != utf8.RuneError || size != 1 {
+			next = runeUnits(r, encoding)
+		}
+		if units+next > character {
+			return i
+		}
+		units += next
+		i += size
+	}
+	return len(line)
+}
+
+// line text for row, or "" if the row is past the end (edits like to insert after the last line)
+func (s *Snapshot) line(row int) string {
+	if row < 0 || row >= len(s.lines) {
+		return ""
+	}
+	return s.lines[row]
+}
+
+// ToProtocolPosition converts a position with a byte offset character into the client's encoding
+func (s *Snapshot) ToProtocolPosition(position lsp.Position) lsp.Position {
+	return lsp.Position{
+		Line:      position.Line,
+		Character: byteToCharacter(s.line(position.Line), position.Character, s.encoding),
+	}
+}
+
+// FromProtocolPosition converts a position from the client into one with a byte offset character
+func (s *Snapshot) FromProtocolPosition(position lsp.Position) lsp.Position {
+	return lsp.Position{
+		Line:      position.Line,
+		Character: characterToByte(s.line(position.Line), position.Character, s.encoding),
+	}
+}
+
+func (s *Snapshot) ToProtocolRange(r lsp.Range) lsp.Range {
+	return lsp.Range{Start: s.ToProtocolPosition(r.Start), End: s.ToProtocolPosition(r.End)}
+}
+
+func (s *Snapshot) FromProtocolRange(r lsp.Range) lsp.Range {
+	return lsp.Range{Start: s.FromProtocolPosition(r.Start), End: s.FromProtocolPosition(r.End)}
+}
+
+// toProtocolEdit converts the ranges of every text edit in place
+func (s *Snapshot) toProtocolEdit(edit *lsp.WorkspaceEdit) {
+	for _, edits := range edit.Changes {
+		for i := range edits {
+			edits[i].Range = s.ToProtocolRange(edits[i].Range)
+		}
+	}
+	for _, change := range edit.DocumentChanges {
+		for i := range change.Edits {
+			change.Edits[i].Range = s.ToProtocolRange(change.Edits[i].Range)
+		}
+	}
+}
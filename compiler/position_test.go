@@ -0,0 +1,60 @@
+package compiler_test
+
+import (
+	"lsp/compiler"
+	"lsp/lsp"
+	"testing"
+)
+
+func TestPositionEncodings(t *testing.T) {
+	// ’ is 3 bytes but one utf-16 unit, 👀 is 4 bytes and a surrogate pair
+	text := "you’ve just opened 👀 the most underwhelming file"
+	byteOffset := len("you’ve just opened 👀 the most ")
+
+	tests := map[lsp.PositionEncodingKind]int{
+		lsp.UTF8:  byteOffset,
+		lsp.UTF16: len("youve just opened  the most ") + 1 + 2,
+		lsp.UTF32: len("youve just opened  the most ") + 1 + 1,
+	}
+
+	for encoding, expected := range tests {
+		snapshot := compiler.NewSnapshot("file:///a.md", 1, text, encoding)
+
+		actual := snapshot.ToProtocolPosition(lsp.Position{Line: 0, Character: byteOffset})
+		if actual.Character != expected {
+			t.Fatalf("%s: Expected: %d, Actual: %d", encoding, expected, actual.Character)
+		}
+
+		back := snapshot.FromProtocolPosition(actual)
+		if back.Character != byteOffset {
+			t.Fatalf("%s: Expected: %d back, Actual: %d", encoding, byteOffset, back.Character)
+		}
+	}
+}
+
+func TestDiagnosticsUseClientEncoding(t *testing.T) {
+	state := compiler.NewState()
+	state.OpenDocument("file:///a.md", 1, "")
+
+	snapshot, err := state.UpdateDocument("file:///a.md", 2, "don’t say VS Code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagnostics := state.Diagnostics(snapshot)
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected: 1 diagnostic, Got: %d", len(diagnostics))
+	}
+
+	// utf-16 by default, so ’ only counts once
+	expected := lsp.Range{Start: lsp.Position{Character: 10}, End: lsp.Position{Character: 17}}
+	if diagnostics[0].Range != expected {
+		t.Fatalf("Expected: %+v, Actual: %+v", expected, diagnostics[0].Range)
+	}
+
+	if encoding := compiler.NegotiateEncoding([]lsp.PositionEncodingKind{lsp.UTF16, lsp.UTF8}); encoding != lsp.UTF8 {
+		t.Fatalf("Expected: utf-8 when offered, Got: %s", encoding)
+	}
+	if encoding := compiler.NegotiateEncoding(nil); encoding != lsp.UTF16 {
+		t.Fatalf("Expected: utf-16 by default, Got: %s", encoding)
+	}
+}
@@ -0,0 +1,46 @@
+package compiler
+
+import (
+	"fmt"
+	"lsp/lsp"
+)
+
+// resultID identifies the diagnostics for one version of a document with one set of rules,
+// see State.generations
+func (s *State) resultID(snapshot *Snapshot) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fmt.Sprintf("%d:%d:%d", s.generations[snapshot.URI], s.rules.version(), snapshot.Version)
+}
+
+// DocumentDiagnostics answers textDocument/diagnostic. if the client already has the result for
+// this version it just gets told nothing changed
+func (s *State) DocumentDiagnostics(snapshot *Snapshot, previousResultID string) lsp.DocumentDiagnosticReport {
+	resultID := s.resultID(snapshot)
+	if previousResultID == resultID {
+		return lsp.DocumentDiagnosticReport{Kind: lsp.ReportUnchanged, ResultID: resultID}
+	}
+
+	return lsp.DocumentDiagnosticReport{
+		Kind:     lsp.ReportFull,
+		ResultID: resultID,
+		Items:    s.Diagnostics(snapshot),
+	}
+}
+
+// WorkspaceDiagnostics answers workspace/diagnostic for every open document, previous maps uri to
+// the result id the client has for it
+func (s *State) WorkspaceDiagnostics(previous map[string]string) lsp.WorkspaceDiagnosticReport {
+	report := lsp.WorkspaceDiagnosticReport{Items: []lsp.WorkspaceDocumentDiagnosticReport{}}
+	for _, snapshot := range s.Snapshots() {
+		version := snapshot.Version
+		report.Items = append(report.Items, lsp.WorkspaceDocumentDiagnosticReport{
+			DocumentDiagnosticReport: s.DocumentDiagnostics(snapshot, previous[snapshot.URI]),
+			URI:                      snapshot.URI,
+			Version:                  &version,
+		})
+	}
+
+	return report
+}
@@ -0,0 +1,70 @@
+package compiler_test
+
+import (
+	"encoding/json"
+	"lsp/compiler"
+	"lsp/lsp"
+	"strings"
+	"testing"
+)
+
+func TestDocumentDiagnosticsResultID(t *testing.T) {
+	state := compiler.NewState()
+	snapshot := state.OpenDocument("file:///a.md", 1, "i use VS Code")
+
+	first := state.DocumentDiagnostics(snapshot, "")
+	if first.Kind != lsp.ReportFull || len(first.Items) == 0 || first.ResultID == "" {
+		t.Fatalf("Expected: a full report with a result id, Got: %+v", first)
+	}
+
+	again := state.DocumentDiagnostics(snapshot, first.ResultID)
+	if again.Kind != lsp.ReportUnchanged || again.Items != nil || again.ResultID != first.ResultID {
+		t.Fatalf("Expected: an unchanged report, Got: %+v", again)
+	}
+
+	snapshot, _ = state.UpdateDocument("file:///a.md", 2, "nothing to see")
+	changed := state.DocumentDiagnostics(snapshot, first.ResultID)
+	if changed.Kind != lsp.ReportFull || changed.ResultID == first.ResultID {
+		t.Fatalf("Expected: a new full report after the edit, Got: %+v", changed)
+	}
+
+	// a full report always sends items, even when there are none
+	encoded, _ := json.Marshal(changed)
+	if !strings.Contains(string(encoded), `"items":[]`) {
+		t.Fatalf("Expected: an empty items list, Got: %s", encoded)
+	}
+
+	other := state.OpenDocument("file:///b.md", 1, "")
+	otherResult := state.DocumentDiagnostics(other, "")
+
+	// closing and reopening at the same version can't reuse the old id
+	state.CloseDocument("file:///a.md")
+	snapshot = state.OpenDocument("file:///a.md", 2, "i use VS Code")
+	if reopened := state.DocumentDiagnostics(snapshot, changed.ResultID); reopened.Kind != lsp.ReportFull {
+		t.Fatalf("Expected: a full report after reopening, Got: %+v", reopened)
+	}
+
+	// but the other documents keep theirs
+	if unchanged := state.DocumentDiagnostics(other, otherResult.ResultID); unchanged.Kind != lsp.ReportUnchanged {
+		t.Fatalf("Expected: b.md to be unchanged after closing a.md, Got: %+v", unchanged)
+	}
+}
+
+func TestWorkspaceDiagnostics(t *testing.T) {
+	state := compiler.NewState()
+	state.OpenDocument("file:///b.md", 3, "Neovim")
+	a := state.OpenDocument("file:///a.md", 1, "")
+
+	known := state.DocumentDiagnostics(a, "")
+	report := state.WorkspaceDiagnostics(map[string]string{"file:///a.md": known.ResultID})
+
+	if len(report.Items) != 2 || report.Items[0].URI != "file:///a.md" || report.Items[1].URI != "file:///b.md" {
+		t.Fatalf("Expected: both open documents in order, Got: %+v", report.Items)
+	}
+	if report.Items[0].Kind != lsp.ReportUnchanged {
+		t.Fatalf("Expected: a.md to be unchanged, Got: %s", report.Items[0].Kind)
+	}
+	if b := report.Items[1]; b.Kind != lsp.ReportFull || *b.Version != 3 || len(b.Items) == 0 {
+		t.Fatalf("Expected: a full report for b.md at version 3, Got: %+v", b)
+	}
+}
@@ -0,0 +1,199 @@
+package compiler
+
+import (
+	"context"
+	"io/fs"
+	"lsp/lsp"
+	"path/filepath"
+	"strings"
+)
+
+// references, Definition the other way around. from a heading to every link in the workspace that
+// goes to it, from a file to every link to it, and from a definition to every link using its label
+
+// References finds what points at the thing at position:
+//   - a heading, or a link to one: every link in the workspace whose anchor goes to the heading
+//   - a link to a file: every link to that file
+//   - a reference or footnote definition, or a link using one: every use of the label
+//
+// includeDeclaration adds the heading or definition itself. found gets the locations one document
+// at a time as soon as they are known, so a big workspace can be streamed to the client. nothing
+// more is looked at once ctx is cancelled
+func (s *State) References(ctx context.Context, snapshot *Snapshot, position lsp.Position, includeDeclaration bool, found func([]lsp.Location)) {
+	ast := snapshot.AST()
+	node := ast.NodeAt(snapshot.FromProtocolPosition(position))
+
+	report := func(document *Snapshot, nodes []*Node) {
+		if len(nodes) == 0 {
+			return
+		}
+		locations := make([]lsp.Location, len(nodes))
+		for i, node := range nodes {
+			locations[i] = lsp.Location{URI: document.URI, Range: document.ToProtocolRange(node.Range)}
+		}
+		found(locations)
+	}
+
+	// labels only mean something inside their own document
+	var kind NodeKind
+	var label string
+	if link := linkAt(node); link != nil && link.Label != "" {
+		kind, label = DefinitionNode, link.Label
+	} else if link != nil {
+		destination, _ := parseDestination(link.Destination)
+		targets := s.destinationTargets(snapshot, link.Destination)
+		if len(targets) == 0 {
+			return
+		}
+		if destination.anchor != "" && targets[0].heading != nil {
+			s.headingReferences(ctx, targets[0].snapshot, targets[0].heading, includeDeclaration, report)
+		} else if path, ok := uriPath(targets[0].uri); ok {
+			s.fileReferences(ctx, path, report)
+		}
+		return
+	} else if heading := node.Ancestor(HeadingNode); heading != nil {
+		s.headingReferences(ctx, snapshot, heading, includeDeclaration, report)
+		return
+	} else if definition := node.Ancestor(DefinitionNode); definition != nil {
+		kind, label = DefinitionNode, definition.Label
+	} else if reference := node.Ancestor(FootnoteReferenceNode); reference != nil {
+		kind, label = FootnoteDefinitionNode, reference.Label
+	} else if footnote := node.Ancestor(FootnoteDefinitionNode); footnote != nil {
+		kind, label = FootnoteDefinitionNode, footnote.Label
+	} else {
+		return
+	}
+
+	label = NormalizeLabel(label)
+	declaration, uses := ast.Definitions[label], []*Node{}
+	if kind == FootnoteDefinitionNode {
+		declaration = ast.Footnotes[label]
+	}
+	if includeDeclaration && declaration != nil {
+		uses = append(uses, declaration)
+	}
+	ast.Root.Walk(func(node *Node) bool {
+		switch node.Kind {
+		case LinkNode, ImageNode:
+			if kind == DefinitionNode && node.Label != "" && NormalizeLabel(node.Label) == label {
+				uses = append(uses, node)
+			}
+		case FootnoteReferenceNode:
+			if kind == FootnoteDefinitionNode && NormalizeLabel(node.Label) == label {
+				uses = append(uses, node)
+			}
+		}
+		return true
+	})
+	report(snapshot, uses)
+}
+
+// headingReferences is every link to heading in document, from anywhere in the workspace
+func (s *State) headingReferences(ctx context.Context, document *Snapshot, heading *Node, includeDeclaration bool, report func(*Snapshot, []*Node)) {
+	anchor := ""
+	for a, node := range document.AST().Anchors() {
+		if node == heading {
+			anchor = a
+		}
+	}
+	if includeDeclaration {
+		report(document, []*Node{heading})
+	}
+	if anchor == "" {
+		return // nothing can link to a heading without an anchor
+	}
+
+	path, _ := uriPath(document.URI)
+	s.workspaceDocuments(ctx, func(from *Snapshot) {
+		report(from, s.linksTo(from, func(destination destination) bool {
+			if destination.anchor != anchor {
+				return false
+			}
+			if destination.path == "" {
+				return from.URI == document.URI
+			}
+			return path != "" && s.leadsTo(from, destination.path, path)
+		}))
+	})
+}
+
+// fileReferences is every link to the file at path, whatever the anchor
+func (s *State) fileReferences(ctx context.Context, path string, report func(*Snapshot, []*Node)) {
+	s.workspaceDocuments(ctx, func(from *Snapshot) {
+		report(from, s.linksTo(from, func(destination destination) bool {
+			return destination.path != "" && s.leadsTo(from, destination.path, path)
+		}))
+	})
+}
+
+// leadsTo is true if a destination path in from can mean the file at path
+func (s *State) leadsTo(from *Snapshot, destination, path string) bool {
+	for _, candidate := range s.candidatePaths(from, destination) {
+		if candidate == path {
+			return true
+		}
+	}
+	return false
+}
+
+// linksTo is every link in document with a destination that matches. a reference link has its
+// destination at the definition, so that is where it gets counted
+func (s *State) linksTo(document *Snapshot, matches func(destination) bool) []*Node {
+	links := []*Node{}
+	document.AST().Root.Walk(func(node *Node) bool {
+		inline := (node.Kind == LinkNode || node.Kind == ImageNode) && node.Label == ""
+		if inline || node.Kind == DefinitionNode {
+			if destination, ok := parseDestination(node.Destination); ok && matches(destination) {
+				links = append(links, node)
+			}
+		}
+		return true
+	})
+	return links
+}
+
+// workspaceDocuments calls fn with every markdown document we know about: the open ones first,
+// then the rest of the workspace folders from disk. hidden folders and node_modules are skipped
+func (s *State) workspaceDocuments(ctx context.Context, fn func(document *Snapshot)) {
+	seen := map[string]bool{}
+	for _, snapshot := range s.Snapshots() {
+		if ctx.Err() != nil {
+			return
+		}
+		if s.IsRulesFile(snapshot.URI) {
+			continue
+		}
+		if path, ok := uriPath(snapshot.URI); ok {
+			seen[path] = true
+		}
+		fn(snapshot)
+	}
+
+	s.mu.RLock()
+	folders := append([]string{}, s.folders...)
+	s.mu.RUnlock()
+	for _, folder := range folders {
+		filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				return nil // can't read it, there is nothing to find in there anyway
+			}
+			if entry.IsDir() {
+				if path != folder && (strings.HasPrefix(entry.Name(), ".") || entry.Name() == "node_modules") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if seen[path] || !isMarkdown(path) {
+				return nil
+			}
+			seen[path] = true // folders can be inside each other
+			if document, ok := s.document(path); ok {
+				fn(document)
+			}
+			return nil
+		})
+	}
+}
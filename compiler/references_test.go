@@ -0,0 +1,126 @@
+package compiler_test
+
+import (
+	"context"
+	"fmt"
+	"lsp/compiler"
+	"lsp/lsp"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReferences(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"guide.md":          "# Guide\n\n## Install Steps\n\nsee [below](#install-steps)\n",
+		"docs/faq.md":       "[how](../guide.md#install-steps) and [the guide](/guide.md)\n\n[ref]: ../guide.md#install-steps\n",
+		".git/ignored.md":   "[hidden](../guide.md#install-steps)\n",
+		"docs/unrelated.md": "[other](../guide.md#guide)\n",
+	})
+	state := compiler.NewState()
+	state.SetWorkspaceFolders([]string{uri(dir)})
+	snapshot := state.OpenDocument(uri(filepath.Join(dir, "notes.md")), 1,
+		"# Notes\n\n[x][a] [y][A] [z](guide.md) note[^1] [w](guide.md#install-steps)\n\n[a]: guide.md#install-steps\n[^1]: the note\n")
+
+	where := func(location lsp.Location) string {
+		path, _ := filepath.Rel(dir, strings.TrimPrefix(location.URI, "file://"))
+		return fmt.Sprintf("%s:%d:%d", path, location.Range.Start.Line, location.Range.Start.Character)
+	}
+
+	tests := []struct {
+		name               string
+		position           lsp.Position
+		includeDeclaration bool
+		expected           []string
+	}{
+		{"a heading in another file, from a link to it", lsp.Position{Line: 2, Character: 38}, true, []string{
+			"docs/faq.md:0:0", "docs/faq.md:2:0", "guide.md:2:0", "guide.md:4:4", "notes.md:2:37", "notes.md:4:0",
+		}},
+		{"without the heading itself", lsp.Position{Line: 2, Character: 38}, false, []string{
+			"docs/faq.md:0:0", "docs/faq.md:2:0", "guide.md:4:4", "notes.md:2:37", "notes.md:4:0",
+		}},
+		{"a file", lsp.Position{Line: 2, Character: 18}, true, []string{
+			"docs/faq.md:0:0", "docs/faq.md:0:37", "docs/faq.md:2:0", "docs/unrelated.md:0:0", "notes.md:2:14", "notes.md:2:37", "notes.md:4:0",
+		}},
+		{"a reference link", lsp.Position{Line: 2, Character: 1}, true, []string{
+			"notes.md:2:0", "notes.md:2:7", "notes.md:4:0",
+		}},
+		{"a reference definition", lsp.Position{Line: 4, Character: 1}, false, []string{
+			"notes.md:2:0", "notes.md:2:7",
+		}},
+		{"a footnote", lsp.Position{Line: 5, Character: 2}, false, []string{
+			"notes.md:2:32",
+		}},
+		{"a heading nothing links to", lsp.Position{Line: 0, Character: 3}, false, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found := []string{}
+			state.References(context.Background(), snapshot, test.position, test.includeDeclaration, func(locations []lsp.Location) {
+				for _, location := range locations {
+					found = append(found, where(location))
+				}
+			})
+			sort.Strings(found)
+			if fmt.Sprint(found) != fmt.Sprint(test.expected) {
+				t.Fatalf("Expected: %v, Got: %v", test.expected, found)
+			}
+		})
+	}
+}
+
+func TestReferencesToAHeadingWithAnEmptySlug(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"b.md": "[x](a.md) [y](a.md#-1)\n"})
+	state := compiler.NewState()
+	state.SetWorkspaceFolders([]string{uri(dir)})
+	a := state.OpenDocument(uri(filepath.Join(dir, "a.md")), 1, "## 🎉\n\n## 🎉\n")
+
+	tests := []struct {
+		name     string
+		position lsp.Position
+		expected int
+	}{
+		{"the heading, no link can get to it", lsp.Position{Line: 0, Character: 3}, 0},
+		{"the heading after it, which has an anchor", lsp.Position{Line: 2, Character: 3}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found := []lsp.Location{}
+			state.References(context.Background(), a, test.position, false, func(locations []lsp.Location) {
+				found = append(found, locations...)
+			})
+			if len(found) != test.expected {
+				t.Fatalf("Expected: %d references, Got: %+v", test.expected, found)
+			}
+		})
+	}
+
+	// a plain link to the file is about the file, not the heading with no anchor
+	b := state.OpenDocument(uri(filepath.Join(dir, "b.md")), 1, "[x](a.md) [y](a.md#-1)\n")
+	found := []lsp.Location{}
+	state.References(context.Background(), b, lsp.Position{Line: 0, Character: 1}, true, func(locations []lsp.Location) {
+		found = append(found, locations...)
+	})
+	if len(found) != 2 || found[0].URI != b.URI || found[1].URI != b.URI {
+		t.Fatalf("Expected: both links to a.md, Got: %+v", found)
+	}
+}
+
+func TestReferencesStopWhenCancelled(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.md": "[b](b.md)\n", "c.md": "[b](b.md)\n"})
+	state := compiler.NewState()
+	state.SetWorkspaceFolders([]string{uri(dir)})
+	snapshot := state.OpenDocument(uri(filepath.Join(dir, "b.md")), 1, "[me](b.md)\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	state.References(ctx, snapshot, lsp.Position{Line: 0, Character: 1}, false, func(locations []lsp.Location) {
+		calls++
+		cancel()
+	})
+	if calls != 1 {
+		t.Fatalf("Expected: nothing else looked at after cancelling, Got: %d batches", calls)
+	}
+}
@@ -0,0 +1,174 @@
+package compiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"lsp/lsp"
+	"sync"
+)
+
+// Rule is one diagnostic check. Check reports ranges as byte offsets into the line like the rest
+// of the compiler, the conversion to the client's encoding happens after the rule has run.
+// a diagnostic left without a severity gets the rule's default one, and without a code the rule's id
+type Rule interface {
+	ID() string
+	Severity() lsp.DiagnosticSeverity
+	Check(snapshot *Snapshot) []lsp.Diagnostic
+}
+
+// Fixer is optional for a rule, it offers code actions for one of the diagnostics that rule
+// reported on the same snapshot (byte offsets again). they travel in the diagnostic's data
+type Fixer interface {
+	Fix(snapshot *Snapshot, diagnostic lsp.Diagnostic) []lsp.CodeAction
+}
+
+// Documented is optional for a rule too, Docs is a link to what the rule is about
+type Documented interface {
+	Docs() string
+}
+
+// Registry holds the rules diagnostics run with, in the order they were registered.
+// it can change while the server is running so everything goes through mu
+type Registry struct {
+	mu    sync.RWMutex
+	rules []Rule
+
+	// bumped on every change, results computed with an older set of rules are out of date
+	generation int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds rules to the registry. ids have to be unique, if one is taken nothing gets added
+func (r *Registry) Register(rules ...Rule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := map[string]bool{}
+	for _, rule := range r.rules {
+		seen[rule.ID()] = true
+	}
+	for _, rule := range rules {
+		if seen[rule.ID()] {
+			return fmt.Errorf("rule %q is already registered", rule.ID())
+		}
+		seen[rule.ID()] = true
+	}
+
+	r.rules = append(r.rules, rules...)
+	r.generation++
+	return nil
+}
+
+// Unregister removes the rule with that id, if there is one
+func (r *Registry) Unregister(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, rule := range r.rules {
+		if rule.ID() == id {
+			// a fresh slice, somebody might still be ranging over what Rules gave them
+			r.rules = append(r.rules[:i:i], r.rules[i+1:]...)
+			r.generation++
+			return
+		}
+	}
+}
+
+// Replace swaps the rules in remove for the ones in add in one go, so diagnostics never run with
+// neither of them. if an id in add is taken by some other rule nothing changes
+func (r *Registry) Replace(remove, add []Rule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	drop := map[string]bool{}
+	for _, rule := range remove {
+		drop[rule.ID()] = true
+	}
+
+	kept := []Rule{}
+	seen := map[string]bool{}
+	for _, rule := range r.rules {
+		if !drop[rule.ID()] {
+			kept = append(kept, rule)
+			seen[rule.ID()] = true
+		}
+	}
+	for _, rule := range add {
+		if seen[rule.ID()] {
+			return fmt.Errorf("rule %q is already registered", rule.ID())
+		}
+		seen[rule.ID()] = true
+	}
+
+	r.rules = append(kept, add...)
+	r.generation++
+	return nil
+}
+
+// Rules is a copy of the registered rules, safe to use while the registry changes
+func (r *Registry) Rules() []Rule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]Rule(nil), r.rules...)
+}
+
+func (r *Registry) version() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.generation
+}
+
+// fixData is what goes in the data of a diagnostic that has quick fixes
+type fixData struct {
+	Fixes []lsp.CodeAction `json:"fixes"`
+}
+
+// runRule checks snapshot with one rule and fills in what the rule left out: the default severity,
+// the rule id as the code, the docs link and the quick fixes as data. everything comes out in the
+// client's encoding
+func runRule(rule Rule, snapshot *Snapshot) []lsp.Diagnostic {
+	fixer, _ := rule.(Fixer)
+	docs := ""
+	if documented, ok := rule.(Documented); ok {
+		docs = documented.Docs()
+	}
+
+	diagnostics := rule.Check(snapshot)
+	for i := range diagnostics {
+		diagnostic := &diagnostics[i]
+		if diagnostic.Severity == 0 {
+			diagnostic.Severity = rule.Severity()
+		}
+		if diagnostic.Code == "" {
+			diagnostic.Code = rule.ID()
+		}
+		if diagnostic.CodeDescription == nil && docs != "" {
+			diagnostic.CodeDescription = &lsp.CodeDescription{Href: docs}
+		}
+
+		if fixer != nil {
+			// the fixer wants byte offsets, so this has to happen before the range gets converted
+			if fixes := fixer.Fix(snapshot, *diagnostic); len(fixes) > 0 {
+				for j := range fixes {
+					if fixes[j].Edit != nil {
+						snapshot.toProtocolEdit(fixes[j].Edit)
+					}
+				}
+				diagnostic.Data, _ = json.Marshal(fixData{Fixes: fixes}) // plain structs, can't fail
+			}
+		}
+
+		diagnostic.Range = snapshot.ToProtocolRange(diagnostic.Range)
+		for j, related := range diagnostic.RelatedInformation {
+			if related.Location.URI == snapshot.URI {
+				diagnostic.RelatedInformation[j].Location.Range = snapshot.ToProtocolRange(related.Location.Range)
+			}
+		}
+	}
+	return diagnostics
+}
@@ -0,0 +1,119 @@
+package compiler_test
+
+import (
+	"lsp/compiler"
+	"lsp/lsp"
+	"strings"
+	"testing"
+)
+
+// flags every line that shouts, and offers to calm it down
+type shoutingRule struct{}
+
+func (shoutingRule) ID() string                       { return "shouting" }
+func (shoutingRule) Severity() lsp.DiagnosticSeverity { return lsp.SeverityWarning }
+
+func (shoutingRule) Check(snapshot *compiler.Snapshot) []lsp.Diagnostic {
+	diagnostics := []lsp.Diagnostic{}
+	for row, line := range snapshot.Lines() {
+		if line != "" && line == strings.ToUpper(line) {
+			diagnostics = append(diagnostics, lsp.Diagnostic{Range: compiler.LineRange(row, 0, len(line)), Message: "inside voice"})
+		}
+	}
+	return diagnostics
+}
+
+func (shoutingRule) Fix(snapshot *compiler.Snapshot, diagnostic lsp.Diagnostic) []lsp.CodeAction {
+	return []lsp.CodeAction{{Title: "Lower case"}}
+}
+
+func TestRegisterRule(t *testing.T) {
+	state := compiler.NewState()
+	if err := state.Rules().Register(shoutingRule{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Rules().Register(shoutingRule{}); err == nil {
+		t.Fatal("Expected: an error for a duplicate rule id")
+	}
+
+	snapshot := state.OpenDocument("file:///a.md", 1, "HELLO\nquiet\n")
+
+	var found *lsp.Diagnostic
+	for _, diagnostic := range state.Diagnostics(snapshot) {
+		if diagnostic.Message == "inside voice" {
+			found = &diagnostic
+		}
+	}
+	if found == nil || found.Severity != 2 || found.Range != compiler.LineRange(0, 0, 5) {
+		t.Fatalf("Expected: the shouting line flagged with the default severity, Got: %+v", found)
+	}
+
+	hasFix := func() bool {
+		for _, action := range state.TextDocumentCodeAction(snapshot, nil) {
+			if action.Title == "Lower case" {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasFix() {
+		t.Fatal("Expected: the rule's quick fix in the code actions")
+	}
+
+	state.Rules().Unregister("shouting")
+	if hasFix() {
+		t.Fatal("Expected: no quick fix once the rule is gone")
+	}
+}
+
+func TestDiagnosticDetails(t *testing.T) {
+	state := compiler.NewState()
+	snapshot := state.OpenDocument("file:///a.md", 1, "VS Code\ncongratulations, this is underwhelming\n")
+
+	diagnostics := state.Diagnostics(snapshot)
+	if len(diagnostics) != 2 {
+		t.Fatalf("Expected: 2 diagnostics, Got: %+v", diagnostics)
+	}
+
+	vsCode := diagnostics[0]
+	if vsCode.Code != "vs-code" || vsCode.Severity != lsp.SeverityError || vsCode.CodeDescription == nil ||
+		!strings.HasSuffix(vsCode.CodeDescription.Href, "#vs-code") || len(vsCode.Tags) != 1 || vsCode.Tags[0] != lsp.TagDeprecated {
+		t.Fatalf("Expected: code, docs link and deprecated tag, Got: %+v", vsCode)
+	}
+	if !strings.Contains(string(vsCode.Data), `"Neovim"`) {
+		t.Fatalf("Expected: the quick fixes in data, Got: %s", vsCode.Data)
+	}
+
+	underwhelming := diagnostics[1]
+	if underwhelming.Severity != lsp.SeverityHint || len(underwhelming.RelatedInformation) != 1 ||
+		underwhelming.RelatedInformation[0].Location.Range != compiler.LineRange(1, 0, 15) {
+		t.Fatalf("Expected: a hint pointing at congratulations, Got: %+v", underwhelming)
+	}
+
+	for _, action := range state.TextDocumentCodeAction(snapshot, nil) {
+		if action.Title == "Censor to VS C*de" {
+			if action.Kind != lsp.QuickFix || len(action.Diagnostics) != 1 || action.Diagnostics[0].Code != "vs-code" {
+				t.Fatalf("Expected: a quick fix for the vs-code diagnostic, Got: %+v", action)
+			}
+			return
+		}
+	}
+	t.Fatal("Expected: the censor quick fix")
+}
+
+func TestHeadingFixReplacesTheWholeHeading(t *testing.T) {
+	state := compiler.NewState()
+	snapshot := state.OpenDocument("file:///a.md", 1, "this is a test\n===\n\nmore\n")
+
+	for _, action := range state.TextDocumentCodeAction(snapshot, nil) {
+		if action.Title == "Replace header with something dramatic" {
+			edit := action.Edit.Changes["file:///a.md"][0]
+			expected := lsp.Range{Start: lsp.Position{Line: 0, Character: 0}, End: lsp.Position{Line: 1, Character: 3}}
+			if edit.Range != expected {
+				t.Fatalf("Expected: the text and its underline replaced, %v, Got: %v", expected, edit.Range)
+			}
+			return
+		}
+	}
+	t.Fatal("Expected: the boring heading quick fix")
+}
@@ -0,0 +1,253 @@
+package compiler
+
+import (
+	"lsp/lsp"
+	"slices"
+	"strings"
+)
+
+// lineRule is the simple kind of rule, it looks at one line at a time and flags every span match finds
+type lineRule struct {
+	id       string
+	severity lsp.DiagnosticSeverity
+	source   string
+	message  string
+	tags     []lsp.DiagnosticTag
+	docs     string
+
+	// what to flag in line
+	match func(line string) []Span
+
+	// optional, the quick fixes for a span match found on row
+	fix func(uri string, row int, line string, start, end int) []lsp.CodeAction
+
+	// optional, other places worth pointing at for a span on row
+	related func(uri string, row int, line string, span Span) []lsp.DiagnosticRelatedInformation
+}
+
+func (r *lineRule) ID() string                       { return r.id }
+func (r *lineRule) Severity() lsp.DiagnosticSeverity { return r.severity }
+func (r *lineRule) Docs() string                     { return r.docs }
+
+// Check looks at the prose of every line, so nothing in code or html gets flagged
+func (r *lineRule) Check(snapshot *Snapshot) []lsp.Diagnostic {
+	diagnostics := []lsp.Diagnostic{}
+	for row, line := range snapshot.Prose() {
+		for _, span := range r.match(line) {
+			diagnostics = append(diagnostics, r.diagnostic(snapshot, row, line, span))
+		}
+	}
+	return diagnostics
+}
+
+func (r *lineRule) diagnostic(snapshot *Snapshot, row int, line string, span Span) lsp.Diagnostic {
+	diagnostic := lsp.Diagnostic{
+		Range:   LineRange(row, span.Start, span.End),
+		Source:  r.source,
+		Message: r.message,
+		Tags:    r.tags,
+	}
+	if r.related != nil {
+		diagnostic.RelatedInformation = r.related(snapshot.URI, row, line, span)
+	}
+	return diagnostic
+}
+
+// headingRule is a lineRule that only looks at the text of headings, match gets what comes
+// after the #s (or the text above a setext underline) one line at a time
+type headingRule struct {
+	lineRule
+	level int // 0 for every level
+
+	// optional, used instead of lineRule's fix. it gets the whole heading, which is two lines for
+	// a setext one, so replacing the heading doesn't leave its underline behind
+	fixHeading func(uri string, heading lsp.Range) []lsp.CodeAction
+}
+
+func (r *headingRule) Check(snapshot *Snapshot) []lsp.Diagnostic {
+	diagnostics := []lsp.Diagnostic{}
+	prose := snapshot.Prose()
+	for _, heading := range snapshot.AST().Find(HeadingNode) {
+		if r.level != 0 && heading.Level != r.level || len(heading.Children) == 0 {
+			continue
+		}
+		start, end := heading.Children[0].Range.Start, heading.Children[len(heading.Children)-1].Range.End
+		for row := start.Line; row <= end.Line; row++ {
+			from, to := 0, len(prose[row])
+			if row == start.Line {
+				from = start.Character
+			}
+			if row == end.Line {
+				to = end.Character
+			}
+			for _, span := range r.match(prose[row][from:to]) {
+				span = Span{Start: from + span.Start, End: from + span.End}
+				diagnostics = append(diagnostics, r.diagnostic(snapshot, row, prose[row], span))
+			}
+		}
+	}
+	return diagnostics
+}
+
+func (r *headingRule) Fix(snapshot *Snapshot, diagnostic lsp.Diagnostic) []lsp.CodeAction {
+	if r.fixHeading == nil {
+		return r.lineRule.Fix(snapshot, diagnostic)
+	}
+	heading := snapshot.AST().NodeAt(diagnostic.Range.Start).Ancestor(HeadingNode)
+	if heading == nil {
+		return nil
+	}
+	return r.fixHeading(snapshot.URI, heading.Range)
+}
+
+func (r *lineRule) Fix(snapshot *Snapshot, diagnostic lsp.Diagnostic) []lsp.CodeAction {
+	if r.fix == nil {
+		return nil
+	}
+	row := diagnostic.Range.Start.Line
+	return r.fix(snapshot.URI, row, snapshot.line(row), diagnostic.Range.Start.Character, diagnostic.Range.End.Character)
+}
+
+// contains matches every time phrase shows up in a line
+func contains(phrase string) func(line string) []Span {
+	return NewMatcher(phrase, MatchOptions{}).FindAll
+}
+
+// replaceWith is a quick fix that swaps the flagged span for text
+func replaceWith(title, text string) func(uri string, row int, line string, start, end int) []lsp.CodeAction {
+	return func(uri string, row int, line string, start, end int) []lsp.CodeAction {
+		return replaceRange(title, text, uri, LineRange(row, start, end))
+	}
+}
+
+// replaceRange is a quick fix that swaps what is at r for text
+func replaceRange(title, text, uri string, r lsp.Range) []lsp.CodeAction {
+	return []lsp.CodeAction{{
+		Title: title,
+		Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
+			uri: {{Range: r, NewText: text}},
+		}},
+	}}
+}
+
+// where the builtin rules are explained, the rule id is the anchor
+const builtinRulesDocs = "https://github.com/shivenaggarwal/lsp/blob/main/RULES.md#"
+
+// BuiltinRules are the checks every State starts with
+func BuiltinRules() []Rule {
+	notReally, yep := contains("not really"), contains("yep. this is it")
+	congratulations, underwhelming := contains("congratulations"), contains("underwhelming")
+
+	return []Rule{
+		// VS Code slander
+		&lineRule{
+			id:       "vs-code",
+			severity: lsp.SeverityError,
+			source:   "Common Sense",
+			message:  "Please don't mention VS Code, no self-respecting dev uses it.",
+			tags:     []lsp.DiagnosticTag{lsp.TagDeprecated}, // as editors go
+			docs:     builtinRulesDocs + "vs-code",
+			match:    contains("VS Code"),
+			fix: func(uri string, row int, line string, start, end int) []lsp.CodeAction {
+				return append(
+					replaceWith("Replace VS C*de with a superior editor", "Neovim")(uri, row, line, start, end),
+					replaceWith("Censor to VS C*de", "VS C*de")(uri, row, line, start, end)...,
+				)
+			},
+		},
+
+		// Neovim appreciation
+		&lineRule{
+			id:       "neovim",
+			severity: lsp.SeverityHint,
+			docs:     builtinRulesDocs + "neovim",
+			source:   "Common Sense",
+			message:  "Great choice dawg.",
+			match:    contains("Neovim"),
+		},
+
+		// Anti-humility check
+		&lineRule{
+			id:       "no-purpose",
+			severity: lsp.SeverityInformation,
+			docs:     builtinRulesDocs + "no-purpose",
+			source:   "Self-Esteem Engine",
+			message:  "This file is *the* purpose. Believe in your LSP era.",
+			match:    contains("this file has no purpose"),
+			fix: func(uri string, row int, line string, start, end int) []lsp.CodeAction {
+				return replaceWith("Strike through self-deprecating line", "~~"+line+"~~")(uri, row, line, 0, len(line))
+			},
+		},
+
+		// Vibe Check
+		&lineRule{
+			id:       "vibe-check",
+			severity: lsp.SeverityInformation,
+			source:   "VibeCheck",
+			message:  "Low-energy response detected.",
+			tags:     []lsp.DiagnosticTag{lsp.TagUnnecessary},
+			docs:     builtinRulesDocs + "vibe-check",
+			match: func(line string) []Span {
+				spans := append(notReally(line), yep(line)...)
+				slices.SortFunc(spans, func(a, b Span) int { return a.Start - b.Start })
+				return spans
+			},
+		},
+
+		// Slang Reminder
+		&lineRule{
+			id:       "flex-police",
+			severity: lsp.SeverityInformation,
+			docs:     builtinRulesDocs + "flex-police",
+			source:   "FlexPolice",
+			message:  "Say it with your chest. Try a flex format like: ‘caught in 4k making history.’",
+			match:    contains("look, i made a thing"),
+		},
+
+		// Main character energy missing
+		&lineRule{
+			id:       "underwhelming",
+			severity: lsp.SeverityHint,
+			source:   "Narrative Core",
+			message:  "Bro you’re underselling it. This is the climax of your origin story.",
+			docs:     builtinRulesDocs + "underwhelming",
+			match: func(line string) []Span {
+				if !strings.Contains(line, "congratulations") {
+					return nil
+				}
+				return underwhelming(line)
+			},
+			related: func(uri string, row int, line string, span Span) []lsp.DiagnosticRelatedInformation {
+				related := []lsp.DiagnosticRelatedInformation{}
+				for _, at := range congratulations(line) {
+					related = append(related, lsp.DiagnosticRelatedInformation{
+						Location: lsp.Location{URI: uri, Range: LineRange(row, at.Start, at.End)},
+						Message:  "the congratulations it undersells",
+					})
+				}
+				return related
+			},
+		},
+
+		// No markdown heading enthusiasm
+		&headingRule{
+			level: 1,
+			lineRule: lineRule{
+				id:       "boring-heading",
+				severity: lsp.SeverityInformation,
+				docs:     builtinRulesDocs + "boring-heading",
+				source:   "Drama Department",
+				message:  "Let’s spice this up. How about: ‘Top-tier markdown drama incoming’?",
+				match: func(text string) []Span {
+					if !strings.HasPrefix(text, "this is a test") {
+						return nil
+					}
+					return []Span{{Start: 0, End: len("this is a test")}}
+				},
+			},
+			fixHeading: func(uri string, heading lsp.Range) []lsp.CodeAction {
+				return replaceRange("Replace header with something dramatic", "# the README that read too much into itself", uri, heading)
+			},
+		},
+	}
+}
@@ -0,0 +1,251 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lsp/lsp"
+	"regexp"
+	"strings"
+)
+
+// the rules file lets people add phrase checks without touching go. it looks like
+//
+//	{
+//	  "rules": [
+//	    {"id": "no-utilize", "pattern": "utilize", "message": "Just say use.", "replacement": "use"},
+//	    {"pattern": "\\bTODO\\b", "regex": true, "severity": 3, "message": "Still a TODO here.", "source": "Docs Team"}
+//	  ]
+//	}
+//
+// pattern and message are required, the rest is optional. ignoreCase and wholeWord work for both
+// kinds of pattern. severity is 1 (error) to 4 (hint) and
+// defaults to 2 (warning), a replacement becomes a quick fix and docs is a link the editor shows
+// next to the rule id. entries with problems are skipped,
+// the problems show up as diagnostics on the rules file itself
+
+// prefixed to every id from the rules file so they can't collide with rules registered in go
+const rulesFilePrefix = "rules-file/"
+
+type ruleEntry struct {
+	ID          string                 `json:"id"`
+	Pattern     string                 `json:"pattern"`
+	Regex       bool                   `json:"regex"`
+	IgnoreCase  bool                   `json:"ignoreCase"`
+	WholeWord   bool                   `json:"wholeWord"`
+	Severity    lsp.DiagnosticSeverity `json:"severity"`
+	Message     string                 `json:"message"`
+	Source      string                 `json:"source"`
+	Replacement *string                `json:"replacement"`
+	Docs        string                 `json:"docs"` // link to more about the rule
+}
+
+// SetRulesFile says which document is the rules file, its diagnostics are the problems in it
+// instead of the usual checks
+func (s *State) SetRulesFile(uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rulesFile = uri
+}
+
+func (s *State) IsRulesFile(uri string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rulesFile != "" && s.rulesFile == uri
+}
+
+// LoadRules replaces the rules from the last rules file with the ones in text. a file that isn't
+// valid json keeps the old rules around, broken entries are just left out. either way the error
+// says there were problems, Diagnostics on the rules file has the details
+func (s *State) LoadRules(text string) error {
+	rules, problems, ok := parseRulesFile(text)
+	if ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if err := s.rules.Replace(s.fileRules, rules); err != nil {
+			return err
+		}
+		s.fileRules = rules
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems in the rules file, first one: %s", len(problems), problems[0].Message)
+	}
+	return nil
+}
+
+func rulesFileDiagnostics(snapshot *Snapshot) []lsp.Diagnostic {
+	_, problems, _ := parseRulesFile(snapshot.Text)
+	for i := range problems {
+		problems[i].Range = snapshot.ToProtocolRange(problems[i].Range)
+	}
+	return problems
+}
+
+// parseRulesFile turns the rules file into rules and the problems it found on the way, with
+// byte offset ranges. ok is false if the file couldn't be read at all
+func parseRulesFile(text string) ([]Rule, []lsp.Diagnostic, bool) {
+	rules := []Rule{}
+	problems := []lsp.Diagnostic{}
+	report := func(start, end int64, severity lsp.DiagnosticSeverity, message string) {
+		problems = append(problems, lsp.Diagnostic{
+			Range:    offsetRange(text, int(start), int(end)),
+			Severity: severity,
+			Source:   "Rules File",
+			Message:  message,
+		})
+	}
+	syntaxError := func(dec *json.Decoder, err error) ([]Rule, []lsp.Diagnostic, bool) {
+		start, end := dec.InputOffset(), dec.InputOffset()
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			start, end = max(0, syntax.Offset-1), syntax.Offset // the offset is just past the bad character
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			start, end, err = int64(len(text)), int64(len(text)), errors.New("unexpected end of file")
+		}
+		report(start, end, lsp.SeverityError, strings.TrimPrefix(err.Error(), "json: "))
+		return nil, problems, false
+	}
+
+	dec := json.NewDecoder(strings.NewReader(text))
+	if err := expectDelim(dec, '{'); err != nil {
+		return syntaxError(dec, err)
+	}
+
+	seen := map[string]bool{}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return syntaxError(dec, err)
+		}
+		key, _ := token.(string)
+		keyEnd := dec.InputOffset()
+
+		if key != "rules" {
+			report(keyEnd-int64(len(key))-2, keyEnd, lsp.SeverityWarning, fmt.Sprintf("unknown key %q, it is ignored", key))
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return syntaxError(dec, err)
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return syntaxError(dec, err)
+		}
+		for dec.More() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return syntaxError(dec, err)
+			}
+			end := dec.InputOffset()
+			start := end - int64(len(raw))
+
+			rule, err := parseRuleEntry(raw, seen)
+			if err != nil {
+				report(start, end, lsp.SeverityError, err.Error())
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return syntaxError(dec, err)
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return syntaxError(dec, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		report(dec.InputOffset(), int64(len(text)), lsp.SeverityError, "unexpected content after the rules")
+	}
+
+	return rules, problems, true
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != want {
+		return fmt.Errorf("expected %q but found %v", want, token)
+	}
+	return nil
+}
+
+// parseRuleEntry checks one entry of the rules list and makes a rule out of it
+func parseRuleEntry(raw json.RawMessage, seen map[string]bool) (Rule, error) {
+	var entry ruleEntry
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&entry); err != nil {
+		return nil, fmt.Errorf("bad rule: %s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+
+	if entry.Pattern == "" {
+		return nil, errors.New("rule needs a pattern")
+	}
+	if entry.Message == "" {
+		return nil, errors.New("rule needs a message")
+	}
+	if entry.Severity == 0 {
+		entry.Severity = lsp.SeverityWarning
+	}
+	if entry.Severity < lsp.SeverityError || entry.Severity > lsp.SeverityHint {
+		return nil, fmt.Errorf("severity %d isn't one of 1 (error), 2 (warning), 3 (info) or 4 (hint)", entry.Severity)
+	}
+	if entry.Source == "" {
+		entry.Source = "Rules File"
+	}
+	if entry.ID == "" {
+		entry.ID = entry.Pattern
+	}
+	if seen[entry.ID] {
+		return nil, fmt.Errorf("there is already a rule with id %q", entry.ID)
+	}
+
+	expr := regexp.QuoteMeta(entry.Pattern)
+	if entry.Regex {
+		expr = entry.Pattern
+	}
+	matcher, err := NewRegexMatcher(expr, MatchOptions{IgnoreCase: entry.IgnoreCase, WholeWord: entry.WholeWord})
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern: %s", err)
+	}
+	if matcher.pattern.MatchString("") {
+		return nil, errors.New("pattern matches the empty string, it would flag every line")
+	}
+	seen[entry.ID] = true
+
+	rule := &lineRule{
+		id:       rulesFilePrefix + entry.ID,
+		severity: entry.Severity,
+		source:   entry.Source,
+		message:  entry.Message,
+		docs:     entry.Docs,
+		match:    matcher.FindAll,
+	}
+	if entry.Replacement != nil {
+		rule.fix = replaceWith(fmt.Sprintf("Replace with '%s'", *entry.Replacement), *entry.Replacement)
+	}
+	return rule, nil
+}
+
+// offsetRange turns byte offsets into the whole text into a range, characters in bytes like
+// everything else before it goes out
+func offsetRange(text string, start, end int) lsp.Range {
+	return lsp.Range{Start: offsetPosition(text, start), End: offsetPosition(text, end)}
+}
+
+func offsetPosition(text string, offset int) lsp.Position {
+	offset = max(0, min(offset, len(text)))
+	line := strings.Count(text[:offset], "\n")
+	return lsp.Position{Line: line, Character: offset - (strings.LastIndexByte(text[:offset], '\n') + 1)}
+}
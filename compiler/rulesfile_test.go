@@ -0,0 +1,100 @@
+package compiler_test
+
+import (
+	"lsp/compiler"
+	"lsp/lsp"
+	"testing"
+)
+
+const rulesFileURI = "file:///rules.json"
+
+func hasMessage(diagnostics []lsp.Diagnostic, message string) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Message == message {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoadRules(t *testing.T) {
+	state := compiler.NewState()
+	state.SetRulesFile(rulesFileURI)
+	doc := state.OpenDocument("file:///a.md", 1, "we utilize markdown\nTODO: 42\n")
+
+	err := state.LoadRules(`{"rules": [
+		{"id": "utilize", "pattern": "utilize", "message": "Just say use.", "replacement": "use"},
+		{"pattern": "TODO: \\d+", "regex": true, "severity": 4, "message": "Numbered TODO."}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics := state.Diagnostics(doc)
+	if !hasMessage(diagnostics, "Just say use.") || !hasMessage(diagnostics, "Numbered TODO.") {
+		t.Fatalf("Expected: both rules from the file to run, Got: %+v", diagnostics)
+	}
+
+	fixed := false
+	for _, action := range state.TextDocumentCodeAction(doc, nil) {
+		if edits := action.Edit.Changes["file:///a.md"]; action.Title == "Replace with 'use'" && edits[0].Range == compiler.LineRange(0, 3, 10) {
+			fixed = true
+		}
+	}
+	if !fixed {
+		t.Fatal("Expected: a quick fix from the replacement")
+	}
+
+	// not json anymore, the old rules stay
+	if err := state.LoadRules(`{"rules": [`); err == nil {
+		t.Fatal("Expected: an error for a broken file")
+	}
+	if !hasMessage(state.Diagnostics(doc), "Just say use.") {
+		t.Fatal("Expected: the rules to survive a broken file")
+	}
+
+	// a reload replaces them
+	if err := state.LoadRules(`{"rules": []}`); err != nil {
+		t.Fatal(err)
+	}
+	if hasMessage(state.Diagnostics(doc), "Just say use.") {
+		t.Fatal("Expected: the rules to be gone after the reload")
+	}
+}
+
+func TestRulesFileDiagnostics(t *testing.T) {
+	state := compiler.NewState()
+	state.SetRulesFile(rulesFileURI)
+
+	text := "{\n" +
+		"  \"colour\": 1,\n" +
+		"  \"rules\": [\n" +
+		"    {\"pattern\": \"ok\", \"message\": \"fine\"},\n" +
+		"    {\"pattern\": \"(\", \"regex\": true, \"message\": \"broken\"},\n" +
+		"    {\"pattern\": \"x\", \"message\": \"loud\", \"severity\": 7},\n" +
+		"    {\"pattern\": \"y\"}\n" +
+		"  ]\n" +
+		"}\n"
+	snapshot := state.OpenDocument(rulesFileURI, 1, text)
+
+	problems := state.Diagnostics(snapshot)
+	if len(problems) != 4 {
+		t.Fatalf("Expected: 4 problems, Got: %+v", problems)
+	}
+	expected := []lsp.Range{
+		compiler.LineRange(1, 2, 10), // "colour"
+		compiler.LineRange(4, 4, 56),
+		compiler.LineRange(5, 4, 54),
+		compiler.LineRange(6, 4, 20),
+	}
+	for i, problem := range problems {
+		if problem.Range != expected[i] {
+			t.Errorf("Expected: %+v for %q, Got: %+v", expected[i], problem.Message, problem.Range)
+		}
+	}
+
+	syntax := state.Diagnostics(state.OpenDocument(rulesFileURI, 2, "{\"rules\": [}\n"))
+	if len(syntax) != 1 || syntax[0].Range != compiler.LineRange(0, 11, 12) {
+		t.Fatalf("Expected: one problem where the syntax breaks, Got: %+v", syntax)
+	}
+}
@@ -0,0 +1,111 @@
+package compiler
+
+import (
+	"lsp/lsp"
+	"strings"
+)
+
+// CloseDocument forgets about a document, the client owns it again
+func (s *State) CloseDocument(uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.documents, uri)
+	s.generations[uri]++
+}
+
+// SaveDocument gives back the usual diagnostics plus the ones that only make sense once the
+// file is on disk. they go away again with the next edit, until the next save
+func (s *State) SaveDocument(snapshot *Snapshot) []lsp.Diagnostic {
+	return append(s.Diagnostics(snapshot), getSaveDiagnosticsForFile(snapshot)...)
+}
+
+// the comment from "<!-- TODO" up to where it closes, or the end of the line
+var todoMatcher = newMatcher(`<!-- TODO(.*?-->|.*)`, MatchOptions{})
+
+func getSaveDiagnosticsForFile(snapshot *Snapshot) []lsp.Diagnostic {
+	diagnostics := []lsp.Diagnostic{}
+	lines := snapshot.Lines()
+
+	for row, line := range lines {
+		// trailing whitespace, except the two spaces markdown uses for a line break
+		if start, end, ok := trailingWhitespace(line); ok {
+			diagnostics = append(diagnostics, lsp.Diagnostic{
+				Range:    LineRange(row, start, end),
+				Severity: lsp.SeverityInformation,
+				Code:     "trailing-whitespace",
+				Source:   "Save Police",
+				Tags:     []lsp.DiagnosticTag{lsp.TagUnnecessary},
+				Message:  "Trailing whitespace. Two spaces is a line break, this is just clutter.",
+			})
+		}
+
+		// leftovers from the "fake TODO" code action
+		for _, span := range todoMatcher.FindAll(line) {
+			diagnostics = append(diagnostics, lsp.Diagnostic{
+				Range:    LineRange(row, span.Start, span.End),
+				Severity: lsp.SeverityInformation,
+				Code:     "todo-on-save",
+				Source:   "Save Police",
+				Message:  "Saved with a TODO still in it. Bold move.",
+			})
+		}
+	}
+
+	if last := len(lines) - 1; lines[last] != "" {
+		diagnostics = append(diagnostics, lsp.Diagnostic{
+			Range:    LineRange(last, len(lines[last]), len(lines[last])),
+			Severity: lsp.SeverityInformation,
+			Code:     "final-newline",
+			Source:   "Save Police",
+			Message:  "No newline at end of file.",
+		})
+	}
+
+	for i := range diagnostics {
+		diagnostics[i].Range = snapshot.ToProtocolRange(diagnostics[i].Range)
+	}
+
+	return diagnostics
+}
+
+// WillSaveWaitUntil returns the fix-on-save edits: trailing whitespace goes and the file
+// always ends with a newline
+func (s *State) WillSaveWaitUntil(snapshot *Snapshot) []lsp.TextEdit {
+	edits := []lsp.TextEdit{}
+	lines := snapshot.Lines()
+
+	for row, line := range lines {
+		if start, end, ok := trailingWhitespace(line); ok {
+			edits = append(edits, lsp.TextEdit{
+				Range:   LineRange(row, start, end),
+				NewText: "",
+			})
+		}
+	}
+
+	if last := len(lines) - 1; lines[last] != "" {
+		end := len(lines[last])
+		edits = append(edits, lsp.TextEdit{
+			Range:   LineRange(last, end, end),
+			NewText: "\n",
+		})
+	}
+
+	for i := range edits {
+		edits[i].Range = snapshot.ToProtocolRange(edits[i].Range)
+	}
+
+	return edits
+}
+
+// finds the trailing whitespace in line, not counting a \r from \r\n line endings.
+// exactly two trailing spaces are a markdown hard line break so those don't count
+func trailingWhitespace(line string) (int, int, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	trimmed := strings.TrimRight(line, " \t")
+	if len(trimmed) == len(line) || line[len(trimmed):] == "  " {
+		return 0, 0, false
+	}
+	return len(trimmed), len(line), true
+}
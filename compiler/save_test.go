@@ -0,0 +1,42 @@
+package compiler_test
+
+import (
+	"lsp/compiler"
+	"lsp/lsp"
+	"testing"
+)
+
+func TestWillSaveWaitUntil(t *testing.T) {
+	state := compiler.NewState()
+	state.OpenDocument("file:///a.md", 1, "keep the break  \ndrop this \t\nfine\nlast line ")
+	snapshot, _ := state.Snapshot("file:///a.md")
+
+	if diagnostics := state.SaveDocument(snapshot); len(diagnostics) != 3 {
+		t.Fatalf("Expected: 3 save diagnostics, Got: %d", len(diagnostics))
+	}
+
+	edits := state.WillSaveWaitUntil(snapshot)
+
+	// apply them back to front like an editor would, so earlier ranges stay valid
+	changes := []lsp.TextDocumentContentChangeEvent{}
+	for i := len(edits) - 1; i >= 0; i-- {
+		changes = append(changes, lsp.TextDocumentContentChangeEvent{Range: &edits[i].Range, Text: edits[i].NewText})
+	}
+	if _, err := state.ChangeDocument("file:///a.md", 2, changes); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _ := state.Snapshot("file:///a.md")
+	expected := "keep the break  \ndrop this\nfine\nlast line\n"
+	if saved.Text != expected {
+		t.Fatalf("Expected: %q, Actual: %q", expected, saved.Text)
+	}
+	if diagnostics := state.SaveDocument(saved); len(diagnostics) != 0 {
+		t.Fatalf("Expected: no save diagnostics after fixing, Got: %+v", diagnostics)
+	}
+
+	state.CloseDocument("file:///a.md")
+	if _, ok := state.Snapshot("file:///a.md"); ok {
+		t.Fatal("Expected: the document to be gone after close")
+	}
+}
@@ -1,203 +1,374 @@
 package compiler
 
 import (
-	"lsp/lsp"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"lsp/lsp"
+	"sort"
 	"strings"
-	)
+	"sync"
+)
+
+// returned when a change arrives with a version that isn't newer than the one we have
+var ErrStaleVersion = errors.New("stale document version")
 
 // basically to track the state of whats going on eg keeping track of our docs
 type State struct {
-	// map of filenames to content
-	Documents map[string]string // whatever the current state of all of the current docs is we save them here
+	// requests run concurrently so every access to documents goes through this
+	mu sync.RWMutex
+
+	// map of uris to the latest snapshot of each doc
+	documents map[string]*Snapshot // whatever the current state of all of the current docs is we save them here
+
+	// negotiated in initialize, every snapshot converts positions with it
+	encoding lsp.PositionEncodingKind
+
+	// paths of the workspace folders from initialize, see links.go
+	folders []string
+
+	// markdown files that aren't open, kept until they change on disk
+	diskMu sync.Mutex
+	disk   map[string]diskDocument
+
+	// every check diagnostics run, starts out with BuiltinRules
+	rules *Registry
+
+	// uri of the json rules file and the rules that came out of it last time, see rulesfile.go
+	rulesFile string
+	fileRules []Rule
+
+	// part of every pull diagnostics result id, by uri. it gets bumped whenever an old id for that
+	// document could mean something else now, like when it's closed and reopened at the same version
+	generations map[string]int
 }
 
-func NewState() State{
-	return State{Documents: map[string]string{}}
+func NewState() *State {
+	rules := NewRegistry()
+	if err := rules.Register(BuiltinRules()...); err != nil {
+		panic(err) // two builtin rules with the same id, nothing a user can do about it
+	}
+	return &State{documents: map[string]*Snapshot{}, encoding: lsp.UTF16, rules: rules, disk: map[string]diskDocument{}, generations: map[string]int{}}
 }
 
-// severity 1: error, 2: hint, 3: info, 4:warning
-func getDiagnosticsForFile(text string) []lsp.Diagnostic {
+// SetPositionEncoding should be called from initialize, before any document is opened
+func (s *State) SetPositionEncoding(encoding lsp.PositionEncodingKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.encoding = encoding
+}
+
+func getDiagnosticsForFile(snapshot *Snapshot, rules []Rule) []lsp.Diagnostic {
 	diagnostics := []lsp.Diagnostic{}
-	for row, line := range strings.Split(text, "\n") {
-
-		// VS Code slander
-		if strings.Contains(line, "VS Code") {
-			idx := strings.Index(line, "VS Code")
-			diagnostics = append(diagnostics, lsp.Diagnostic{
-				Range:    LineRange(row, idx, idx+len("VS Code")),
-				Severity: 1,
-				Source:   "Common Sense",
-				Message:  "Please don't mention VS Code, no self-respecting dev uses it.",
-			})
-		}
+	for _, rule := range rules {
+		diagnostics = append(diagnostics, runRule(rule, snapshot)...)
+	}
+	return diagnostics
+}
 
-		// Neovim appreciation
-		if strings.Contains(line, "Neovim") {
-			idx := strings.Index(line, "Neovim")
-			diagnostics = append(diagnostics, lsp.Diagnostic{
-				Range:    LineRange(row, idx, idx+len("Neovim")),
-				Severity: 2,
-				Source:   "Common Sense",
-				Message:  "Great choice dawg.",
-			})
-		}
+// Diagnostics runs every registered rule on a snapshot, or checks it if it is the rules file
+func (s *State) Diagnostics(snapshot *Snapshot) []lsp.Diagnostic {
+	if s.IsRulesFile(snapshot.URI) {
+		return rulesFileDiagnostics(snapshot)
+	}
+	return getDiagnosticsForFile(snapshot, s.rules.Rules())
+}
 
-		// Anti-humility check
-		if strings.Contains(line, "this file has no purpose") {
-			idx := strings.Index(line, "this file has no purpose")
-			diagnostics = append(diagnostics, lsp.Diagnostic{
-				Range:    LineRange(row, idx, idx+len("this file has no purpose")),
-				Severity: 3,
-				Source:   "Self-Esteem Engine",
-				Message:  "This file is *the* purpose. Believe in your LSP era.",
-			})
-		}
+// Rules is where diagnostics come from, register more on it to add checks
+func (s *State) Rules() *Registry {
+	return s.rules
+}
 
-		// Vibe Check
-		if strings.Contains(line, "not really") || strings.Contains(line, "yep. this is it") {
-			idx := strings.Index(line, "not really")
-			if idx < 0 {
-				idx = strings.Index(line, "yep. this is it")
-			}
-			diagnostics = append(diagnostics, lsp.Diagnostic{
-				Range:    LineRange(row, idx, idx+len("not really")),
-				Severity: 3,
-				Source:   "VibeCheck",
-				Message:  "Low-energy response detected.",
-			})
-		}
+func (s *State) OpenDocument(uri string, version int, text string) *Snapshot {
+	if path, ok := uriPath(uri); ok {
+		s.forgetDisk(path)
+	}
+	return s.store(uri, version, text)
+}
 
-		// Slang Reminder
-		if strings.Contains(line, "look, i made a thing") {
-			idx := strings.Index(line, "look, i made a thing")
-			diagnostics = append(diagnostics, lsp.Diagnostic{
-				Range:    LineRange(row, idx, idx+len("look, i made a thing")),
-				Severity: 3,
-				Source:   "FlexPolice",
-				Message:  "Say it with your chest. Try a flex format like: ‘caught in 4k making history.’",
-			})
+// UpdateDocument replaces the whole text of a document
+func (s *State) UpdateDocument(uri string, version int, text string) (*Snapshot, error) {
+	return s.ChangeDocument(uri, version, []lsp.TextDocumentContentChangeEvent{{Text: text}})
+}
+
+// ChangeDocument applies the content changes from a didChange, in order, on top of the latest snapshot.
+// versions only ever go up, a change that isn't newer than what we have is rejected with ErrStaleVersion
+func (s *State) ChangeDocument(uri string, version int, changes []lsp.TextDocumentContentChangeEvent) (*Snapshot, error) {
+	for {
+		s.mu.RLock()
+		current, ok := s.documents[uri]
+		encoding := s.encoding
+		s.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("document not open: %s", uri)
+		}
+		if version <= current.Version {
+			return nil, fmt.Errorf("%w: %s got version %d but already has %d", ErrStaleVersion, uri, version, current.Version)
 		}
 
-		// Main character energy missing
-		if strings.Contains(line, "congratulations") && strings.Contains(line, "underwhelming") {
-			idx := strings.Index(line, "underwhelming")
-			diagnostics = append(diagnostics, lsp.Diagnostic{
-				Range:    LineRange(row, idx, idx+len("underwhelming")),
-				Severity: 2,
-				Source:   "Narrative Core",
-				Message:  "Bro you’re underselling it. This is the climax of your origin story.",
-			})
+		text, err := applyChanges(current.Text, changes, encoding)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", uri, err)
 		}
 
-		// No markdown heading enthusiasm
-		if strings.HasPrefix(line, "# this is a test") {
-			idx := strings.Index(line, "this is a test")
-			diagnostics = append(diagnostics, lsp.Diagnostic{
-				Range:    LineRange(row, idx, idx+len("this is a test")),
-				Severity: 3,
-				Source:   "Drama Department",
-				Message:  "Let’s spice this up. How about: ‘Top-tier markdown drama incoming’?",
-			})
+		// only the blocks the changes touched get parsed again, without holding up everyone else
+		snapshot := NewSnapshot(uri, version, text, encoding)
+		snapshot.setAST(Reparse(current.AST(), current.Lines(), snapshot.Lines()))
+
+		s.mu.Lock()
+		if s.documents[uri] == current {
+			s.documents[uri] = snapshot
+			s.mu.Unlock()
+			return snapshot, nil
 		}
+		s.mu.Unlock()
+		// something else changed the document in the meantime, go again on top of that
 	}
-
-	return diagnostics
 }
 
+func (s *State) store(uri string, version int, text string) *Snapshot {
+	ast := Parse(text) // before locking, a whole document can take a moment
 
-func (s *State) OpenDocument(uri, text string) []lsp.Diagnostic {
-	s.Documents[uri] = text
-	
-	return getDiagnosticsForFile(text)
+	s.mu.Lock()
+	snapshot := NewSnapshot(uri, version, text, s.encoding)
+	snapshot.setAST(ast)
+	s.documents[uri] = snapshot
+	s.mu.Unlock()
+
+	return snapshot
 }
 
-func (s *State) UpdateDocument(uri, text string) []lsp.Diagnostic {
-	s.Documents[uri] = text
+// IsCurrent is false once a newer version of the snapshot's document came in (or it got closed),
+// anything computed from it might point at the wrong place by now
+func (s *State) IsCurrent(snapshot *Snapshot) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return getDiagnosticsForFile(text)
+	return s.documents[snapshot.URI] == snapshot
 }
 
-func (s *State) Hover(id int, uri string, position lsp.Position) lsp.HoverResponse {
-	// the function of this is to look up the type in our compiler code (will add it in future)
+// Snapshot gives back the latest version of a document. it is safe to keep using it
+// after more edits come in, it just won't see them
+func (s *State) Snapshot(uri string) (*Snapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	document := s.Documents[uri]
+	snapshot, ok := s.documents[uri]
+	return snapshot, ok
+}
 
-	return lsp.HoverResponse{
-		Response: lsp.Response{
-			RPC: "2.0",
-			ID:  &id,
-		},
-		Result: lsp.HoverResult{
-			Contents: fmt.Sprintf("File: %s, Characters: %d", uri, len(document)),
-		},
+// Snapshots is the latest snapshot of every open document, sorted by uri
+func (s *State) Snapshots() []*Snapshot {
+	s.mu.RLock()
+	snapshots := make([]*Snapshot, 0, len(s.documents))
+	for _, snapshot := range s.documents {
+		snapshots = append(snapshots, snapshot)
 	}
+	s.mu.RUnlock()
+
+	// map order is random, keep it stable for whoever reports on them
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].URI < snapshots[j].URI })
+	return snapshots
 }
 
-func (s *State) Definition(id int, uri string, position lsp.Position) lsp.DefinitionResponse {
-	// this would look up the position
-	// but for now im gonna put this as definition to be one line above
+func (s *State) Hover(snapshot *Snapshot, position lsp.Position, client *lsp.ClientCapabilities) lsp.HoverResult {
+	ast := snapshot.AST()
+	node, description := describe(ast, ast.NodeAt(snapshot.FromProtocolPosition(position)))
+
+	var result lsp.HoverResult
+	if node == nil {
+		// nothing interesting under the cursor, say something about the file instead
+		description = fmt.Sprintf("**File:** `%s`\n\n**Characters:** %d", snapshot.URI, len(snapshot.Text))
+	} else {
+		r := snapshot.ToProtocolRange(node.Range)
+		result.Range = &r
+	}
 
-	return lsp.DefinitionResponse{
-		Response: lsp.Response{
-			RPC: "2.0",
-			ID:  &id,
-		},
-		Result: lsp.Location{
-			URI: uri,
-			Range: lsp.Range{
-				Start: lsp.Position{
-					Line:      position.Line - 1,
-					Character: 0,
-				},
-				End: lsp.Position{
-					Line:      position.Line - 1,
-					Character: 0,
-				},
-			},
-		},
+	if client.SupportsMarkdownHover() {
+		result.Contents = lsp.MarkupContent{Kind: lsp.Markdown, Value: description}
+	} else {
+		result.Contents = lsp.MarkupContent{Kind: lsp.PlainText, Value: stripMarkdown(description)}
 	}
+	return result
 }
 
-func (s *State) TextDocumentCodeAction(id int, uri string) lsp.TextDocumentCodeActionResponse {
-	text := s.Documents[uri]
-	lines := strings.Split(text, "\n")
+// describe finds the closest node from node up that is worth a hover and says what it is in
+// markdown. node is nil if there isn't one
+func describe(ast *AST, node *Node) (*Node, string) {
+	for ; node != nil; node = node.Parent {
+		switch node.Kind {
+		case LinkNode, ImageNode:
+			kind := "Link"
+			if node.Kind == ImageNode {
+				kind = "Image"
+			}
+			description := fmt.Sprintf("**%s:** `%s`", kind, node.Destination)
+			if node.Label != "" {
+				description += fmt.Sprintf(" from `[%s]`", node.Label)
+			}
+			if node.Title != "" {
+				description += "\n\n" + node.Title
+			}
+			return node, description
+
+		case FootnoteReferenceNode:
+			footnote := ast.Footnotes[NormalizeLabel(node.Label)]
+			return node, fmt.Sprintf("**Footnote:** `[^%s]`\n\n%s", node.Label, footnote.Text())
 
-	actions := []lsp.CodeAction{}
+		case DefinitionNode:
+			return node, fmt.Sprintf("**Definition:** `[%s]` is `%s`", node.Label, node.Destination)
 
-	for row, line := range lines[:len(lines)-1] { // exclude the last line
-		// Replace "VS Code" with "Neovim"
-		if idx := strings.Index(line, "VS Code"); idx >= 0 {
-			replaceChange := map[string][]lsp.TextEdit{
-				uri: {{
-					Range:   LineRange(row, idx, idx+len("VS Code")),
-					NewText: "Neovim",
-				}},
+		case HeadingNode:
+			return node, fmt.Sprintf("**Heading:** level %d", node.Level)
+
+		case CodeBlockNode:
+			if language, _, _ := strings.Cut(node.Info, " "); language != "" {
+				return node, fmt.Sprintf("**Code block:** `%s`", language)
 			}
-			actions = append(actions, lsp.CodeAction{
-				Title: "Replace VS C*de with a superior editor",
-				Edit:  &lsp.WorkspaceEdit{Changes: replaceChange},
-			})
+			return node, "**Code block**"
 
-			censorChange := map[string][]lsp.TextEdit{
-				uri: {{
-					Range:   LineRange(row, idx, idx+len("VS Code")),
-					NewText: "VS C*de",
-				}},
+		case ItemNode:
+			if !node.Task {
+				continue
 			}
-			actions = append(actions, lsp.CodeAction{
-				Title: "Censor to VS C*de",
-				Edit:  &lsp.WorkspaceEdit{Changes: censorChange},
-			})
+			if node.Checked {
+				return node, "**Task:** done"
+			}
+			return node, "**Task:** not done"
+
+		case TableNode:
+			return node, fmt.Sprintf("**Table:** %d columns, %d rows", len(node.Alignments), len(node.Children)-1)
+		}
+	}
+	return nil, ""
+}
+
+// stripMarkdown takes the markdown out of a hover for clients that can't show it
+func stripMarkdown(markdown string) string {
+	return strings.NewReplacer("**", "", "`", "").Replace(markdown)
+}
+
+// Definition is where the thing at position points: the definition of a reference link, the body
+// of a footnote, or the file (and heading) a link, image or definition goes to. when that is
+// ambiguous, like a label defined twice or a path that could be two files, every target is there,
+// the one that counts first
+func (s *State) Definition(snapshot *Snapshot, position lsp.Position) []lsp.LocationLink {
+	ast := snapshot.AST()
+	node := ast.NodeAt(snapshot.FromProtocolPosition(position))
+
+	var origin *Node
+	var targets []target
+	if link := linkAt(node); link != nil && link.Label != "" {
+		origin, targets = link, labelTargets(snapshot, DefinitionNode, link.Label)
+	} else if link != nil {
+		origin, targets = link, s.destinationTargets(snapshot, link.Destination)
+	} else if reference := node.Ancestor(FootnoteReferenceNode); reference != nil {
+		origin, targets = reference, labelTargets(snapshot, FootnoteDefinitionNode, reference.Label)
+	} else if definition := node.Ancestor(DefinitionNode); definition != nil {
+		origin, targets = definition, s.destinationTargets(snapshot, definition.Destination)
+	}
+
+	links := []lsp.LocationLink{}
+	for _, target := range targets {
+		originRange := snapshot.ToProtocolRange(origin.Range)
+		link := lsp.LocationLink{OriginSelectionRange: &originRange, TargetURI: target.uri}
+		if target.snapshot != nil {
+			link.TargetRange = target.snapshot.ToProtocolRange(target.full)
+			link.TargetSelectionRange = target.snapshot.ToProtocolRange(target.selection)
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// target is somewhere a definition can go, ranges are in bytes like everywhere in the compiler
+type target struct {
+	uri       string
+	snapshot  *Snapshot // nil for files that aren't markdown, they get pointed at as a whole
+	heading   *Node     // set if it is a section
+	full      lsp.Range
+	selection lsp.Range
+}
+
+// labelTargets is every definition of kind in the document with label, in order. the first one is
+// the one links use, the others are only there to show something is off
+func labelTargets(snapshot *Snapshot, kind NodeKind, label string) []target {
+	targets := []target{}
+	for _, definition := range snapshot.AST().Find(kind) {
+		if NormalizeLabel(definition.Label) == NormalizeLabel(label) {
+			targets = append(targets, target{uri: snapshot.URI, snapshot: snapshot, full: definition.Range, selection: definition.Range})
+		}
+	}
+	return targets
+}
+
+// destinationTargets is where a link destination from snapshot goes. a markdown file with an
+// anchor goes to the section of that heading, anything else to the top of the file
+func (s *State) destinationTargets(snapshot *Snapshot, raw string) []target {
+	destination, ok := parseDestination(raw)
+	if !ok {
+		return nil
+	}
+
+	documents := []*Snapshot{}
+	targets := []target{}
+	if destination.path == "" {
+		documents = append(documents, snapshot)
+	}
+	for _, path := range s.resolvePaths(snapshot, destination.path) {
+		if !isMarkdown(path) {
+			targets = append(targets, target{uri: fileURI(path)})
+		} else if document, ok := s.document(path); ok {
+			documents = append(documents, document)
+		}
+	}
+
+	for _, document := range documents {
+		ast := document.AST()
+		var heading *Node
+		if destination.anchor != "" {
+			heading = ast.Anchors()[destination.anchor]
+		}
+		if heading != nil {
+			targets = append(targets, target{uri: document.URI, snapshot: document, heading: heading, full: ast.Section(heading), selection: heading.Range})
+		} else if destination.path != "" {
+			targets = append(targets, target{uri: document.URI, snapshot: document}) // no such heading, the file is the next best thing
 		}
+	}
+	return targets
+}
+
+// linkAt is the link or image node is in, if any
+func linkAt(node *Node) *Node {
+	if link := node.Ancestor(LinkNode); link != nil {
+		return link
+	}
+	return node.Ancestor(ImageNode)
+}
+
+var (
+	underwhelmingMatcher = NewMatcher("underwhelming", MatchOptions{})
+	coolMatcher          = NewMatcher("cool", MatchOptions{WholeWord: true}) // not the one in "coolant"
+)
+
+func (s *State) TextDocumentCodeAction(snapshot *Snapshot, client *lsp.ClientCapabilities) []lsp.CodeAction {
+	uri := snapshot.URI
+	lines := snapshot.Lines()
+	prose := snapshot.Prose() // what to look at, line is what gets edited
+
+	actions := []lsp.CodeAction{}
+
+	for row, line := range lines[:len(lines)-1] { // exclude the last line
+		text := prose[row]
 
 		// Underwhelming → masterpiece
-		if idx := strings.Index(line, "underwhelming"); idx >= 0 {
+		for _, span := range underwhelmingMatcher.FindAll(text) {
 			actions = append(actions, lsp.CodeAction{
 				Title: "Replace 'underwhelming' with 'masterpiece'",
 				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
 					uri: {{
-						Range:   LineRange(row, idx, idx+len("underwhelming")),
+						Range:   LineRange(row, span.Start, span.End),
 						NewText: "masterpiece",
 					}},
 				}},
@@ -205,7 +376,7 @@ func (s *State) TextDocumentCodeAction(id int, uri string) lsp.TextDocumentCodeA
 		}
 
 		// Answer rhetorical question
-		if strings.Contains(line, "does it do anything cool") {
+		if strings.Contains(text, "does it do anything cool") {
 			actions = append(actions, lsp.CodeAction{
 				Title: "Answer rhetorical question with sarcasm",
 				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
@@ -217,21 +388,8 @@ func (s *State) TextDocumentCodeAction(id int, uri string) lsp.TextDocumentCodeA
 			})
 		}
 
-		// Strikethrough self-deprecation
-		if strings.Contains(line, "this file has no purpose") {
-			actions = append(actions, lsp.CodeAction{
-				Title: "Strike through self-deprecating line",
-				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
-					uri: {{
-						Range:   LineRange(row, 0, len(line)),
-						NewText: "~~" + line + "~~",
-					}},
-				}},
-			})
-		}
-
 		// Add dramatic emoji to ends with "." or "..."
-		if strings.HasSuffix(line, "...") || strings.HasSuffix(line, ".") {
+		if strings.HasSuffix(text, "...") || strings.HasSuffix(text, ".") {
 			actions = append(actions, lsp.CodeAction{
 				Title: "Add dramatic emoji for flair",
 				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
@@ -243,21 +401,8 @@ func (s *State) TextDocumentCodeAction(id int, uri string) lsp.TextDocumentCodeA
 			})
 		}
 
-		// Replace markdown header with emoji title
-		if strings.HasPrefix(line, "# this is a test") {
-			actions = append(actions, lsp.CodeAction{
-				Title: "Replace header with something dramatic",
-				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
-					uri: {{
-						Range:   LineRange(row, 0, len(line)),
-						NewText: "# the README that read too much into itself",
-					}},
-				}},
-			})
-		}
-
 		// Emphasize ego line
-		if strings.Contains(line, "boosting my ego") {
+		if strings.Contains(text, "boosting my ego") {
 			actions = append(actions, lsp.CodeAction{
 				Title: "Emphasize ego line with blockquote",
 				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
@@ -269,21 +414,8 @@ func (s *State) TextDocumentCodeAction(id int, uri string) lsp.TextDocumentCodeA
 			})
 		}
 
-		// Add fake TODO after the title
-		if row == 1 {
-			actions = append(actions, lsp.CodeAction{
-				Title: "Add fake TODO to look productive",
-				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
-					uri: {{
-						Range:   LineRange(row+1, 0, 0),
-						NewText: "\n<!-- TODO: add real content to this file -->\n\n",
-					}},
-				}},
-			})
-		}
-
 		// Replace "yep. this is it." with dramatic message
-		if strings.Contains(line, "yep. this is it") {
+		if strings.Contains(text, "yep. this is it") {
 			actions = append(actions, lsp.CodeAction{
 				Title: "Replace 'yep. this is it.' with something dramatic",
 				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
@@ -296,12 +428,12 @@ func (s *State) TextDocumentCodeAction(id int, uri string) lsp.TextDocumentCodeA
 		}
 
 		// Italicize "cool"
-		if idx := strings.Index(line, "cool"); idx >= 0 {
+		for _, span := range coolMatcher.FindAll(text) {
 			actions = append(actions, lsp.CodeAction{
 				Title: "Italicize 'cool' for ironic tone",
 				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
 					uri: {{
-						Range:   LineRange(row, idx, idx+len("cool")),
+						Range:   LineRange(row, span.Start, span.End),
 						NewText: "*cool*",
 					}},
 				}},
@@ -309,7 +441,7 @@ func (s *State) TextDocumentCodeAction(id int, uri string) lsp.TextDocumentCodeA
 		}
 
 		// Replace "say 'look...'" line with bolder message
-		if strings.Contains(line, "say \"look, i made a thing\"") {
+		if strings.Contains(text, "say \"look, i made a thing\"") {
 			actions = append(actions, lsp.CodeAction{
 				Title: "Make statement bolder",
 				Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
@@ -322,16 +454,87 @@ func (s *State) TextDocumentCodeAction(id int, uri string) lsp.TextDocumentCodeA
 		}
 	}
 
-	return lsp.TextDocumentCodeActionResponse{
-		Response: lsp.Response{
-			RPC: "2.0",
-			ID:  &id,
-		},
-		Result: actions,
+	// Add fake TODO after the title
+	if headings := snapshot.AST().Find(HeadingNode); len(headings) > 0 {
+		actions = append(actions, lsp.CodeAction{
+			Title: "Add fake TODO to look productive",
+			Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
+				uri: {{
+					Range:   LineRange(headings[0].Range.End.Line+1, 0, 0),
+					NewText: "\n<!-- TODO: add real content to this file -->\n\n",
+				}},
+			}},
+		})
+	}
+
+	for i := range actions {
+		if actions[i].Edit != nil {
+			snapshot.toProtocolEdit(actions[i].Edit)
+		}
 	}
+
+	// the quick fixes from the rules come first, they are already in the client's encoding
+	actions = append(quickFixes(s.Diagnostics(snapshot)), actions...)
+
+	// clients that understand documentChanges get edits pinned to the version we looked at
+	if client.SupportsDocumentChanges() {
+		for i := range actions {
+			if actions[i].Edit != nil {
+				actions[i].Edit = versionedEdit(actions[i].Edit, snapshot.Version)
+			}
+		}
+	}
+
+	return actions
 }
 
-func (s *State) TextDocumentCompletion(id int, uri string) lsp.CompletionResponse {
+// quickFixes collects the fixes the rules put in the data of their diagnostics
+func quickFixes(diagnostics []lsp.Diagnostic) []lsp.CodeAction {
+	actions := []lsp.CodeAction{}
+	seen := map[string]bool{}
+	for _, diagnostic := range diagnostics {
+		var data fixData
+		if len(diagnostic.Data) == 0 || json.Unmarshal(diagnostic.Data, &data) != nil {
+			continue
+		}
+
+		fixed := diagnostic
+		fixed.Data = nil // the fixes don't need to travel twice
+		for _, action := range data.Fixes {
+			// two hits on one line can come up with the same whole-line fix
+			key := action.Title
+			if action.Edit != nil {
+				key += fmt.Sprint(action.Edit.Changes)
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			action.Kind = lsp.QuickFix
+			action.Diagnostics = []lsp.Diagnostic{fixed}
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// turns the plain uri -> edits map into documentChanges for one document version
+func versionedEdit(edit *lsp.WorkspaceEdit, version int) *lsp.WorkspaceEdit {
+	versioned := &lsp.WorkspaceEdit{}
+	for uri, edits := range edit.Changes {
+		versioned.DocumentChanges = append(versioned.DocumentChanges, lsp.TextDocumentEdit{
+			TextDocument: lsp.OptionalVersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri},
+				Version:                &version,
+			},
+			Edits: edits,
+		})
+	}
+	return versioned
+}
+
+func (s *State) TextDocumentCompletion(snapshot *Snapshot, client *lsp.ClientCapabilities) []lsp.CompletionItem {
 	items := []lsp.CompletionItem{
 		// social/internet-style completions
 		{
@@ -443,15 +646,43 @@ func (s *State) TextDocumentCompletion(id int, uri string) lsp.CompletionRespons
 		},
 	}
 
-	response := lsp.CompletionResponse{
-		Response: lsp.Response{
-			RPC: "2.0",
-			ID:  &id,
-		},
-		Result: items,
+	// these only make sense with tab stops, so they are left out for clients without snippets
+	if client.SupportsSnippets() {
+		items = append(items, snippetCompletions...)
 	}
 
-	return response
+	return items
+}
+
+var snippetCompletions = []lsp.CompletionItem{
+	{
+		Label:            "link",
+		Detail:           "Markdown link",
+		Documentation:    "Link text first, then the url.",
+		InsertText:       "[${1:text}](${2:url})$0",
+		InsertTextFormat: lsp.SnippetFormat,
+	},
+	{
+		Label:            "code block",
+		Detail:           "Fenced code block",
+		Documentation:    "Pick the language, then write the code.",
+		InsertText:       "```${1:go}\n$0\n```",
+		InsertTextFormat: lsp.SnippetFormat,
+	},
+	{
+		Label:            "task",
+		Detail:           "Task list item",
+		Documentation:    "Unchecked task, for the productive era.",
+		InsertText:       "- [ ] ${1:thing to do}$0",
+		InsertTextFormat: lsp.SnippetFormat,
+	},
+	{
+		Label:            "TODO before deadline",
+		Detail:           "Task note",
+		Documentation:    "Same as the TODO note but you fill in the blanks.",
+		InsertText:       "TODO: ${1:implement feature} before ${2:deadline}$0",
+		InsertTextFormat: lsp.SnippetFormat,
+	},
 }
 
 func LineRange(line, start, end int) lsp.Range {
@@ -0,0 +1,129 @@
+package compiler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lsp/compiler"
+	"lsp/lsp"
+	"sync"
+	"testing"
+)
+
+func TestSnapshotIsStable(t *testing.T) {
+	state := compiler.NewState()
+	state.OpenDocument("file:///a.md", 1, "hello")
+
+	before, ok := state.Snapshot("file:///a.md")
+	if !ok {
+		t.Fatal("Expected: the document to be open")
+	}
+
+	state.UpdateDocument("file:///a.md", 2, "hello world")
+
+	if before.Text != "hello" || before.Version != 1 {
+		t.Fatalf("Expected: 'hello' at version 1, Got: %q at version %d", before.Text, before.Version)
+	}
+
+	after, _ := state.Snapshot("file:///a.md")
+	if after.Text != "hello world" || after.Version != 2 {
+		t.Fatalf("Expected: 'hello world' at version 2, Got: %q at version %d", after.Text, after.Version)
+	}
+}
+
+func TestStateConcurrentAccess(t *testing.T) {
+	state := compiler.NewState()
+	state.OpenDocument("file:///a.md", 1, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for v := 2; v < 50; v++ {
+				state.UpdateDocument("file:///a.md", v, fmt.Sprintf("VS Code %d", v))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				snapshot, _ := state.Snapshot("file:///a.md")
+				state.Hover(snapshot, compiler.LineRange(0, 0, 0).Start, nil)
+				state.TextDocumentCodeAction(snapshot, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// whatever order the changes landed in, an older one never replaces a newer one
+	if snapshot, _ := state.Snapshot("file:///a.md"); snapshot.Version != 49 || snapshot.Text != "VS Code 49" {
+		t.Fatalf("Expected: %q at version 49, Got: %q at version %d", "VS Code 49", snapshot.Text, snapshot.Version)
+	}
+}
+
+func TestFeaturesFollowClientCapabilities(t *testing.T) {
+	state := compiler.NewState()
+	state.OpenDocument("file:///a.md", 1, "VS Code\n")
+	snapshot, _ := state.Snapshot("file:///a.md")
+
+	var fancy lsp.ClientCapabilities
+	if err := json.Unmarshal([]byte(`{
+		"textDocument": {
+			"hover": {"contentFormat": ["markdown", "plaintext"]},
+			"completion": {"completionItem": {"snippetSupport": true}}
+		},
+		"workspace": {"workspaceEdit": {"documentChanges": true}}
+	}`), &fancy); err != nil {
+		t.Fatal(err)
+	}
+
+	if kind := state.Hover(snapshot, lsp.Position{}, nil).Contents.Kind; kind != lsp.PlainText {
+		t.Fatalf("Expected: plaintext hover by default, Got: %s", kind)
+	}
+	if kind := state.Hover(snapshot, lsp.Position{}, &fancy).Contents.Kind; kind != lsp.Markdown {
+		t.Fatalf("Expected: markdown hover, Got: %s", kind)
+	}
+
+	countSnippets := func(items []lsp.CompletionItem) int {
+		n := 0
+		for _, item := range items {
+			if item.InsertTextFormat == lsp.SnippetFormat {
+				n++
+			}
+		}
+		return n
+	}
+	if n := countSnippets(state.TextDocumentCompletion(snapshot, nil)); n != 0 {
+		t.Fatalf("Expected: no snippets by default, Got: %d", n)
+	}
+	if n := countSnippets(state.TextDocumentCompletion(snapshot, &fancy)); n == 0 {
+		t.Fatal("Expected: snippets when the client supports them")
+	}
+
+	for _, action := range state.TextDocumentCodeAction(snapshot, &fancy) {
+		if action.Edit.Changes != nil || len(action.Edit.DocumentChanges) != 1 {
+			t.Fatalf("Expected: documentChanges only, Got: %+v", action.Edit)
+		}
+	}
+}
+
+func TestStaleChangesAreRejected(t *testing.T) {
+	state := compiler.NewState()
+	state.OpenDocument("file:///a.md", 3, "hello")
+	old, _ := state.Snapshot("file:///a.md")
+
+	_, err := state.UpdateDocument("file:///a.md", 2, "older")
+	if !errors.Is(err, compiler.ErrStaleVersion) {
+		t.Fatalf("Expected: ErrStaleVersion, Got: %v", err)
+	}
+	if !state.IsCurrent(old) {
+		t.Fatal("Expected: a rejected change to leave the document alone")
+	}
+
+	if _, err := state.UpdateDocument("file:///a.md", 4, "newer"); err != nil {
+		t.Fatal(err)
+	}
+	if state.IsCurrent(old) {
+		t.Fatal("Expected: the old snapshot to be stale after an update")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+	"lsp/compiler"
+	"lsp/lsp"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// how long a document has to sit still before we run diagnostics on it, unless the client
+// picks something else with initializationOptions.diagnosticsDelay
+const defaultDiagnosticsDelay = 200 * time.Millisecond
+
+// diagnosticsScheduler debounces diagnostics per document. every change restarts the timer for
+// that uri, and when it fires we only do the work if nobody published that version already and
+// only publish if no newer version showed up while we were busy
+type diagnosticsScheduler struct {
+	logger  *log.Logger
+	state   *compiler.State
+	publish func(uri string, version int, diagnostics []lsp.Diagnostic)
+
+	mu        sync.Mutex
+	delay     time.Duration
+	timers    map[string]*time.Timer
+	published map[string]int // last version we sent diagnostics for
+}
+
+func newDiagnosticsScheduler(logger *log.Logger, state *compiler.State, delay time.Duration, publish func(uri string, version int, diagnostics []lsp.Diagnostic)) *diagnosticsScheduler {
+	return &diagnosticsScheduler{
+		logger:    logger,
+		state:     state,
+		publish:   publish,
+		delay:     delay,
+		timers:    map[string]*time.Timer{},
+		published: map[string]int{},
+	}
+}
+
+func (d *diagnosticsScheduler) setDelay(delay time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.delay = delay
+}
+
+// schedule (re)starts the debounce timer for uri
+func (d *diagnosticsScheduler) schedule(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if timer, ok := d.timers[uri]; ok {
+		timer.Stop()
+	}
+	d.timers[uri] = time.AfterFunc(d.delay, func() { d.run(uri) })
+}
+
+// run is on a timer's goroutine, out of reach of router.Recover, so a rule that panics would take
+// the whole server down without this
+func (d *diagnosticsScheduler) run(uri string) {
+	defer func() {
+		if p := recover(); p != nil {
+			d.logger.Printf("panic in diagnostics for %s: %v\n%s", uri, p, debug.Stack())
+		}
+	}()
+
+	snapshot, ok := d.state.Snapshot(uri)
+	if !ok {
+		return // closed in the meantime
+	}
+
+	d.mu.Lock()
+	done := d.publishedAlready(snapshot)
+	d.mu.Unlock()
+	if done {
+		return
+	}
+
+	diagnostics := d.state.Diagnostics(snapshot)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// superseded while we were working (the newer version has its own timer), or a save got in first
+	if !d.state.IsCurrent(snapshot) || d.publishedAlready(snapshot) {
+		return
+	}
+	d.send(snapshot, diagnostics)
+}
+
+// recheck runs diagnostics for uri again after the debounce even if its version was published
+// already, for when the rules changed under it
+func (d *diagnosticsScheduler) recheck(uri string) {
+	d.mu.Lock()
+	delete(d.published, uri)
+	d.mu.Unlock()
+
+	d.schedule(uri)
+}
+
+// publishNow skips the debounce, for didOpen and didSave where nobody is typing
+func (d *diagnosticsScheduler) publishNow(snapshot *compiler.Snapshot, diagnostics []lsp.Diagnostic) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.send(snapshot, diagnostics)
+}
+
+// send happens with mu held so two publishes for the same uri can't overtake each other
+func (d *diagnosticsScheduler) send(snapshot *compiler.Snapshot, diagnostics []lsp.Diagnostic) {
+	d.published[snapshot.URI] = snapshot.Version
+	d.publish(snapshot.URI, snapshot.Version, diagnostics)
+}
+
+func (d *diagnosticsScheduler) publishedAlready(snapshot *compiler.Snapshot) bool {
+	version, ok := d.published[snapshot.URI]
+	return ok && version >= snapshot.Version
+}
+
+// forget drops everything about uri, for didClose
+func (d *diagnosticsScheduler) forget(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if timer, ok := d.timers[uri]; ok {
+		timer.Stop()
+	}
+	delete(d.timers, uri)
+	delete(d.published, uri)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"log"
+	"lsp/compiler"
+	"lsp/lsp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiagnosticsAreDebounced(t *testing.T) {
+	state := compiler.NewState()
+
+	var mu sync.Mutex
+	var published []int
+	diagnostics := newDiagnosticsScheduler(log.New(io.Discard, "", 0), state, 20*time.Millisecond, func(uri string, version int, diagnostics []lsp.Diagnostic) {
+		mu.Lock()
+		defer mu.Unlock()
+		published = append(published, version)
+	})
+
+	state.OpenDocument("file:///a.md", 1, "")
+	for version := 2; version <= 20; version++ { // typing fast
+		if _, err := state.UpdateDocument("file:///a.md", version, "VS Code"); err != nil {
+			t.Fatal(err)
+		}
+		diagnostics.schedule("file:///a.md")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(published) != 1 || published[0] != 20 {
+		t.Fatalf("Expected: one publish for version 20, Got: %v", published)
+	}
+}
+
+func TestDiagnosticsSkipPublishedVersions(t *testing.T) {
+	state := compiler.NewState()
+
+	var mu sync.Mutex
+	publishes := 0
+	diagnostics := newDiagnosticsScheduler(log.New(io.Discard, "", 0), state, 10*time.Millisecond, func(uri string, version int, diagnostics []lsp.Diagnostic) {
+		mu.Lock()
+		defer mu.Unlock()
+		publishes++
+	})
+
+	snapshot := state.OpenDocument("file:///a.md", 1, "VS Code")
+	diagnostics.schedule("file:///a.md")
+	diagnostics.publishNow(snapshot, state.SaveDocument(snapshot)) // a save settles it first
+
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if publishes != 1 {
+		t.Fatalf("Expected: the debounced run to skip an already published version, Got: %d publishes", publishes)
+	}
+}
+
+// a rule that blows up on everything
+type panickingRule struct{}
+
+func (panickingRule) ID() string                       { return "panicking" }
+func (panickingRule) Severity() lsp.DiagnosticSeverity { return lsp.SeverityWarning }
+func (panickingRule) Check(snapshot *compiler.Snapshot) []lsp.Diagnostic {
+	panic("rule went wrong")
+}
+
+// logLines hands every line logged to whoever is waiting on it
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	l <- string(p)
+	return len(p), nil
+}
+
+func TestDiagnosticsRecoverFromPanickingRules(t *testing.T) {
+	state := compiler.NewState()
+	if err := state.Rules().Register(panickingRule{}); err != nil {
+		t.Fatal(err)
+	}
+	logs := make(logLines, 1)
+	diagnostics := newDiagnosticsScheduler(log.New(logs, "", 0), state, 0, func(uri string, version int, diagnostics []lsp.Diagnostic) {
+		t.Errorf("Expected: nothing published for a run that panicked, Got: %v", diagnostics)
+	})
+
+	state.OpenDocument("file:///a.md", 1, "hello")
+	diagnostics.schedule("file:///a.md") // would take the test binary down with it
+
+	select {
+	case line := <-logs:
+		if !strings.Contains(line, "panic in diagnostics for file:///a.md: rule went wrong") {
+			t.Fatalf("Expected: the panic logged, Got: %s", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected: the panic logged, Got: nothing")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+	"lsp/lsp"
+	"lsp/router"
+	"sync"
+	"time"
+)
+
+type lifecycleState int
+
+const (
+	uninitialized lifecycleState = iota // waiting for initialize
+	running                             // initialize went through, business as usual
+	shutDown                            // got shutdown, only exit is allowed now
+)
+
+// how often we check if the editor that started us is still around
+const parentPollInterval = 5 * time.Second
+
+// lifecycle keeps track of initialize -> shutdown -> exit and turns away anything
+// that comes in at the wrong time
+type lifecycle struct {
+	logger *log.Logger
+	exit   func(code int) // os.Exit, swapped out in tests
+
+	mu    sync.Mutex
+	state lifecycleState
+}
+
+func newLifecycle(logger *log.Logger, exit func(code int)) *lifecycle {
+	return &lifecycle{logger: logger, exit: exit}
+}
+
+func (l *lifecycle) current() lifecycleState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.state
+}
+
+// middleware rejects requests made before initialize with ServerNotInitialized and everything
+// after shutdown with InvalidRequest. notifications at the wrong time are dropped, except exit
+func (l *lifecycle) middleware() router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(ctx context.Context, req *router.Request) (any, error) {
+			if req.Method == "exit" {
+				return next(ctx, req)
+			}
+
+			switch l.current() {
+			case uninitialized:
+				if req.Method == "initialize" {
+					return next(ctx, req)
+				}
+				if req.IsNotification() {
+					return nil, nil
+				}
+				return nil, lsp.NewResponseError(lsp.ServerNotInitialized, "server not initialized, got %s before initialize", req.Method)
+
+			case running:
+				if req.Method == "initialize" {
+					return nil, lsp.NewResponseError(lsp.InvalidRequest, "initialize can only be sent once")
+				}
+				return next(ctx, req)
+
+			default:
+				if req.IsNotification() {
+					return nil, nil
+				}
+				return nil, lsp.NewResponseError(lsp.InvalidRequest, "server is shutting down, got %s after shutdown", req.Method)
+			}
+		}
+	}
+}
+
+// initialized is called once initialize succeeds. if the client told us its process id
+// we keep an eye on it and leave if the editor dies without telling us
+func (l *lifecycle) initialized(processID *int) {
+	l.mu.Lock()
+	l.state = running
+	l.mu.Unlock()
+
+	if processID != nil {
+		go l.watchParent(*processID)
+	}
+}
+
+func (l *lifecycle) shutdown() {
+	l.mu.Lock()
+	l.state = shutDown
+	l.mu.Unlock()
+}
+
+// exitCode is 0 if we were shut down properly before exit (or the stream ending) and 1 otherwise
+func (l *lifecycle) exitCode() int {
+	if l.current() == shutDown {
+		return 0
+	}
+	return 1
+}
+
+func (l *lifecycle) watchParent(pid int) {
+	ticker := time.NewTicker(parentPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !processAlive(pid) {
+			l.logger.Printf("parent process %d is gone, exiting", pid)
+			l.exit(1)
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"lsp/compiler"
+	"lsp/lsp"
+	"lsp/router"
+	"testing"
+)
+
+func newTestRouter(logger *log.Logger, life *lifecycle) *router.Router {
+	state := compiler.NewState()
+	conn := router.NewConn(nil, io.Discard, logger)
+	diagnostics := newDiagnosticsScheduler(logger, state, 0, func(uri string, version int, diagnostics []lsp.Diagnostic) {})
+	return newRouter(logger, conn, state, life, &session{}, diagnostics)
+}
+
+func TestLifecycle(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	exitCode := -1
+	life := newLifecycle(logger, func(code int) { exitCode = code })
+	rt := newTestRouter(logger, life)
+
+	errorCode := func(msg string) lsp.ErrorCode {
+		t.Helper()
+		encoded, err := json.Marshal(rt.Dispatch(context.Background(), []byte(msg)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply lsp.Response
+		if err := json.Unmarshal(encoded, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Error == nil {
+			return 0
+		}
+		return reply.Error.Code
+	}
+
+	if code := errorCode(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{}}`); code != lsp.ServerNotInitialized {
+		t.Fatalf("Expected: %d before initialize, Got: %d", lsp.ServerNotInitialized, code)
+	}
+	if code := errorCode(`{"jsonrpc":"2.0","id":2,"method":"initialize","params":{}}`); code != 0 {
+		t.Fatalf("Expected: initialize to work, Got: %d", code)
+	}
+	if code := errorCode(`{"jsonrpc":"2.0","id":3,"method":"initialize","params":{}}`); code != lsp.InvalidRequest {
+		t.Fatalf("Expected: %d for a second initialize, Got: %d", lsp.InvalidRequest, code)
+	}
+	if code := errorCode(`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`); code != 0 {
+		t.Fatalf("Expected: shutdown to work, Got: %d", code)
+	}
+	if code := errorCode(`{"jsonrpc":"2.0","id":5,"method":"textDocument/hover","params":{}}`); code != lsp.InvalidRequest {
+		t.Fatalf("Expected: %d after shutdown, Got: %d", lsp.InvalidRequest, code)
+	}
+
+	rt.Dispatch(context.Background(), []byte(`{"jsonrpc":"2.0","method":"exit"}`))
+	if exitCode != 0 {
+		t.Fatalf("Expected: exit code 0 after shutdown, Got: %d", exitCode)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	exitCode := -1
+	life := newLifecycle(logger, func(code int) { exitCode = code })
+	rt := newTestRouter(logger, life)
+
+	rt.Dispatch(context.Background(), []byte(`{"jsonrpc":"2.0","method":"exit"}`))
+	if exitCode != 1 {
+		t.Fatalf("Expected: exit code 1 without shutdown, Got: %d", exitCode)
+	}
+}
@@ -0,0 +1,156 @@
+package lsp
+
+// what the editor told us it can do in initialize. every field is optional so everything
+// is a pointer, the helpers at the bottom are safe to call on nil
+type ClientCapabilities struct {
+	Workspace    *WorkspaceClientCapabilities    `json:"workspace,omitempty"`
+	TextDocument *TextDocumentClientCapabilities `json:"textDocument,omitempty"`
+	General      *GeneralClientCapabilities      `json:"general,omitempty"`
+}
+
+type DynamicRegistrationCapabilities struct {
+	// the client lets us register this capability later with client/registerCapability
+	DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
+}
+
+type WorkspaceClientCapabilities struct {
+	ApplyEdit              bool                             `json:"applyEdit,omitempty"`
+	WorkspaceEdit          *WorkspaceEditClientCapabilities `json:"workspaceEdit,omitempty"`
+	DidChangeConfiguration *DynamicRegistrationCapabilities `json:"didChangeConfiguration,omitempty"`
+	DidChangeWatchedFiles  *DynamicRegistrationCapabilities `json:"didChangeWatchedFiles,omitempty"`
+	Configuration          bool                             `json:"configuration,omitempty"`
+	Diagnostics            *DiagnosticWorkspaceCapabilities `json:"diagnostics,omitempty"`
+}
+
+type WorkspaceEditClientCapabilities struct {
+	// versioned document edits instead of the plain uri -> edits map
+	DocumentChanges bool `json:"documentChanges,omitempty"`
+}
+
+type DiagnosticWorkspaceCapabilities struct {
+	RefreshSupport bool `json:"refreshSupport,omitempty"`
+}
+
+type TextDocumentClientCapabilities struct {
+	Synchronization    *TextDocumentSyncClientCapabilities   `json:"synchronization,omitempty"`
+	Completion         *CompletionClientCapabilities         `json:"completion,omitempty"`
+	Hover              *HoverClientCapabilities              `json:"hover,omitempty"`
+	Definition         *DefinitionClientCapabilities         `json:"definition,omitempty"`
+	References         *DynamicRegistrationCapabilities      `json:"references,omitempty"`
+	CodeAction         *DynamicRegistrationCapabilities      `json:"codeAction,omitempty"`
+	PublishDiagnostics *PublishDiagnosticsClientCapabilities `json:"publishDiagnostics,omitempty"`
+	Diagnostic         *DiagnosticClientCapabilities         `json:"diagnostic,omitempty"`
+}
+
+type TextDocumentSyncClientCapabilities struct {
+	DynamicRegistrationCapabilities
+	WillSave          bool `json:"willSave,omitempty"`
+	WillSaveWaitUntil bool `json:"willSaveWaitUntil,omitempty"`
+	DidSave           bool `json:"didSave,omitempty"`
+}
+
+type CompletionClientCapabilities struct {
+	DynamicRegistrationCapabilities
+	CompletionItem *CompletionItemCapabilities `json:"completionItem,omitempty"`
+}
+
+type CompletionItemCapabilities struct {
+	SnippetSupport      bool         `json:"snippetSupport,omitempty"`
+	DocumentationFormat []MarkupKind `json:"documentationFormat,omitempty"`
+}
+
+type HoverClientCapabilities struct {
+	DynamicRegistrationCapabilities
+	// in order of preference
+	ContentFormat []MarkupKind `json:"contentFormat,omitempty"`
+}
+
+type DefinitionClientCapabilities struct {
+	DynamicRegistrationCapabilities
+	LinkSupport bool `json:"linkSupport,omitempty"`
+}
+
+type PublishDiagnosticsClientCapabilities struct {
+	RelatedInformation     bool `json:"relatedInformation,omitempty"`
+	VersionSupport         bool `json:"versionSupport,omitempty"`
+	CodeDescriptionSupport bool `json:"codeDescriptionSupport,omitempty"`
+	DataSupport            bool `json:"dataSupport,omitempty"`
+}
+
+// the client can pull diagnostics with textDocument/diagnostic
+type DiagnosticClientCapabilities struct {
+	DynamicRegistrationCapabilities
+	RelatedDocumentSupport bool `json:"relatedDocumentSupport,omitempty"`
+}
+
+type GeneralClientCapabilities struct {
+	// in order of preference, if it's missing the client only knows utf-16
+	PositionEncodings []PositionEncodingKind `json:"positionEncodings,omitempty"`
+}
+
+func (c *ClientCapabilities) textDocument() *TextDocumentClientCapabilities {
+	if c == nil || c.TextDocument == nil {
+		return &TextDocumentClientCapabilities{}
+	}
+	return c.TextDocument
+}
+
+func (c *ClientCapabilities) workspace() *WorkspaceClientCapabilities {
+	if c == nil || c.Workspace == nil {
+		return &WorkspaceClientCapabilities{}
+	}
+	return c.Workspace
+}
+
+// SupportsMarkdownHover is true if the client listed markdown as a hover content format
+func (c *ClientCapabilities) SupportsMarkdownHover() bool {
+	hover := c.textDocument().Hover
+	if hover == nil {
+		return false
+	}
+	for _, kind := range hover.ContentFormat {
+		if kind == Markdown {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsDefinitionLinks is true if definition results can be LocationLinks instead of Locations
+func (c *ClientCapabilities) SupportsDefinitionLinks() bool {
+	definition := c.textDocument().Definition
+	return definition != nil && definition.LinkSupport
+}
+
+func (c *ClientCapabilities) SupportsSnippets() bool {
+	completion := c.textDocument().Completion
+	return completion != nil && completion.CompletionItem != nil && completion.CompletionItem.SnippetSupport
+}
+
+func (c *ClientCapabilities) SupportsDocumentChanges() bool {
+	edit := c.workspace().WorkspaceEdit
+	return edit != nil && edit.DocumentChanges
+}
+
+func (c *ClientCapabilities) SupportsPullDiagnostics() bool {
+	return c.textDocument().Diagnostic != nil
+}
+
+// SupportsDiagnosticRefresh means we can ask with workspace/diagnostic/refresh for everything to be pulled again
+func (c *ClientCapabilities) SupportsDiagnosticRefresh() bool {
+	diagnostics := c.workspace().Diagnostics
+	return diagnostics != nil && diagnostics.RefreshSupport
+}
+
+func (c *ClientCapabilities) SupportsWatchedFilesRegistration() bool {
+	watched := c.workspace().DidChangeWatchedFiles
+	return watched != nil && watched.DynamicRegistration
+}
+
+// PositionEncodings is what the client can take, utf-16 if it didn't say
+func (c *ClientCapabilities) PositionEncodings() []PositionEncodingKind {
+	if c == nil || c.General == nil || len(c.General.PositionEncodings) == 0 {
+		return []PositionEncodingKind{UTF16}
+	}
+	return c.General.PositionEncodings
+}
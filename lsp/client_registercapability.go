@@ -0,0 +1,13 @@
+// server -> client, asks the client to turn on a capability after initialize
+
+package lsp
+
+type RegistrationParams struct {
+	Registrations []Registration `json:"registrations"`
+}
+
+type Registration struct {
+	ID              string `json:"id"`     // ours to pick, the client uses it to unregister
+	Method          string `json:"method"` // what gets turned on, e.g. workspace/didChangeWatchedFiles
+	RegisterOptions any    `json:"registerOptions,omitempty"`
+}
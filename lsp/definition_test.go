@@ -0,0 +1,35 @@
+package lsp_test
+
+import (
+	"encoding/json"
+	"lsp/lsp"
+	"testing"
+)
+
+func TestDefinitionResult(t *testing.T) {
+	origin := lsp.Range{End: lsp.Position{Character: 4}}
+	links := []lsp.LocationLink{{
+		OriginSelectionRange: &origin,
+		TargetURI:            "file:///a.md",
+		TargetRange:          lsp.Range{End: lsp.Position{Line: 3}},
+		TargetSelectionRange: lsp.Range{End: lsp.Position{Character: 7}},
+	}}
+
+	tests := []struct {
+		result   lsp.DefinitionResult
+		expected string
+	}{
+		{lsp.DefinitionResult{Links: links, LinkSupport: true}, `[{"originSelectionRange":{"start":{"line":0,"character":0},"end":{"line":0,"character":4}},"targetUri":"file:///a.md","targetRange":{"start":{"line":0,"character":0},"end":{"line":3,"character":0}},"targetSelectionRange":{"start":{"line":0,"character":0},"end":{"line":0,"character":7}}}]`},
+		{lsp.DefinitionResult{Links: links}, `[{"uri":"file:///a.md","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":7}}}]`},
+		{lsp.DefinitionResult{LinkSupport: true}, `[]`},
+	}
+	for _, test := range tests {
+		actual, err := json.Marshal(test.result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != test.expected {
+			t.Errorf("Expected: %s, Actual: %s", test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+type idKind int
+
+const (
+	idNull idKind = iota
+	idNumber
+	idString
+)
+
+// json-rpc lets the id be a number, a string or null, this keeps track of which one we got
+// so we send back exactly the same thing. the zero value is the null id
+type ID struct {
+	kind idKind
+	num  int64
+	str  string
+}
+
+func NewNumberID(n int64) ID {
+	return ID{kind: idNumber, num: n}
+}
+
+func NewStringID(s string) ID {
+	return ID{kind: idString, str: s}
+}
+
+func (id ID) IsNull() bool {
+	return id.kind == idNull
+}
+
+// for logging, strings get quoted so "1" and 1 don't look the same
+func (id ID) String() string {
+	switch id.kind {
+	case idNumber:
+		return strconv.FormatInt(id.num, 10)
+	case idString:
+		return strconv.Quote(id.str)
+	}
+	return "null"
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	switch id.kind {
+	case idNumber:
+		return json.Marshal(id.num)
+	case idString:
+		return json.Marshal(id.str)
+	}
+	return []byte("null"), nil
+}
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*id = ID{}
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*id = NewStringID(s)
+		return nil
+	}
+
+	// numbers have to be integers, 1.5 is not a valid id
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid id %s: must be an integer, a string or null", data)
+	}
+	*id = NewNumberID(n)
+	return nil
+}
@@ -0,0 +1,45 @@
+package lsp_test
+
+import (
+	"encoding/json"
+	"lsp/lsp"
+	"testing"
+)
+
+func TestIDRoundTrip(t *testing.T) {
+	for _, raw := range []string{`1`, `-7`, `"1"`, `"abc-123"`, `null`} {
+		var id lsp.ID
+		if err := json.Unmarshal([]byte(raw), &id); err != nil {
+			t.Fatalf("%s: %s", raw, err)
+		}
+
+		actual, err := json.Marshal(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != raw {
+			t.Fatalf("Expected: %s, Actual: %s", raw, actual)
+		}
+	}
+
+	var id lsp.ID
+	if err := json.Unmarshal([]byte(`1.5`), &id); err == nil {
+		t.Fatalf("Expected an error for a fractional id, Got: %s", id)
+	}
+}
+
+func TestResponseEchoesStringID(t *testing.T) {
+	var request lsp.Request
+	if err := json.Unmarshal([]byte(`{"jsonrpc":"2.0","id":"42","method":"textDocument/hover"}`), &request); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := json.Marshal(lsp.Response{RPC: "2.0", ID: request.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"jsonrpc":"2.0","id":"42"}`
+	if string(actual) != expected {
+		t.Fatalf("Expected: %s, Actual: %s", expected, actual)
+	}
+}
@@ -6,21 +6,52 @@ type InitializeRequest struct {
 }
 
 type InitializeRequestParams struct {
-	ClientInfo *ClientInfo `json:"clientInfo"`
+	// the editor that started us, if it dies we should exit too. null if it didn't say
+	ProcessID    *int               `json:"processId"`
+	ClientInfo   *ClientInfo        `json:"clientInfo"`
+	Capabilities ClientCapabilities `json:"capabilities"`
+
+	// our own settings, the client passes them through as is
+	InitializationOptions *InitializationOptions `json:"initializationOptions,omitempty"`
+
+	// the folders the editor has open, links starting with / are relative to them. older clients
+	// only send rootUri
+	RootURI          *string           `json:"rootUri"`
+	WorkspaceFolders []WorkspaceFolder `json:"workspaceFolders,omitempty"`
 
 	// TODO add more params to handle a real language
 }
 
+type WorkspaceFolder struct {
+	URI  string `json:"uri"`
+	Name string `json:"name"`
+}
+
+// Folders is the uri of every workspace folder, or just the root if the client didn't send folders
+func (p InitializeRequestParams) Folders() []string {
+	folders := []string{}
+	for _, folder := range p.WorkspaceFolders {
+		folders = append(folders, folder.URI)
+	}
+	if len(folders) == 0 && p.RootURI != nil {
+		folders = append(folders, *p.RootURI)
+	}
+	return folders
+}
+
+type InitializationOptions struct {
+	// milliseconds to wait after the last keystroke before running diagnostics
+	DiagnosticsDelay *int `json:"diagnosticsDelay,omitempty"`
+
+	// a json file with more phrase and regex rules, see compiler/rulesfile.go for what goes in it
+	RulesFile *string `json:"rulesFile,omitempty"`
+}
+
 type ClientInfo struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
 }
 
-type InitializeResponse struct {
-	Response
-	Result InitializeResult `json:"result"`
-}
-
 // this is what our lsp will reply with
 type InitializeResult struct {
 	Capabilities ServerCapabilities `json:"capabilities"`
@@ -28,21 +59,36 @@ type InitializeResult struct {
 }
 
 type ServerCapabilities struct {
-	// TextDocumentSync int `json:"textDocumentSync"` // TODO incremental updates, etc
+	// which of the client's positionEncodings we picked, utf-16 if this is left out
+	PositionEncoding PositionEncodingKind `json:"positionEncoding,omitempty"`
+
 	TextDocumentSync TextDocumentSyncOptions `json:"textDocumentSync"`
 
-	HoverProvider bool `json:"hoverProvider"`
-	DefinitionProvider bool `json:"definitionProvider"`
-	CodeActionProvider bool `json:"codeActionProvider"`
+	HoverProvider      bool           `json:"hoverProvider"`
+	DefinitionProvider bool           `json:"definitionProvider"`
+	ReferencesProvider bool           `json:"referencesProvider"`
+	CodeActionProvider bool           `json:"codeActionProvider"`
 	CompletionProvider map[string]any `json:"completionProvider"`
+
+	// only there if the client can pull diagnostics, otherwise we keep pushing them
+	DiagnosticProvider *DiagnosticOptions `json:"diagnosticProvider,omitempty"`
 }
 
 type TextDocumentSyncOptions struct {
-	OpenClose bool `json:"openClose"`
-	Change    int  `json:"change"` // 1 = Full, 2 = Incremental
-	Save      SaveOptions `json:"save"`
+	OpenClose         bool                 `json:"openClose"`
+	Change            TextDocumentSyncKind `json:"change"`
+	WillSaveWaitUntil bool                 `json:"willSaveWaitUntil"`
+	Save              SaveOptions          `json:"save"`
 }
 
+type TextDocumentSyncKind int
+
+const (
+	SyncNone        TextDocumentSyncKind = 0
+	SyncFull        TextDocumentSyncKind = 1 // the whole document on every change
+	SyncIncremental TextDocumentSyncKind = 2 // only the ranges that changed
+)
+
 type SaveOptions struct {
 	IncludeText bool `json:"includeText"`
 }
@@ -52,35 +98,26 @@ type ServerInfo struct {
 	Version string `json:"version"`
 }
 
-func NewInitializeResponse(id int) InitializeResponse {
-	return InitializeResponse{
-		Response: Response{
-			RPC: "2.0",
-			ID:  &id,
-		},
-			
-		Result: InitializeResult{
-			Capabilities: ServerCapabilities{
-				TextDocumentSync: TextDocumentSyncOptions{
-					OpenClose: true,
-					Change:    1, // Full document sync
-					Save: SaveOptions{
-						IncludeText: true,
-					},
+func NewInitializeResult() InitializeResult {
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync: TextDocumentSyncOptions{
+				OpenClose:         true,
+				Change:            SyncIncremental,
+				WillSaveWaitUntil: true,
+				Save: SaveOptions{
+					IncludeText: true,
 				},
-				HoverProvider: true,
-				DefinitionProvider: true,
-				CodeActionProvider: true,
-				CompletionProvider: map[string]any{},
-			},
-			ServerInfo: ServerInfo{
-				Name:    "lsp",
-				Version: "0.0.1-beta1.final",
 			},
+			HoverProvider:      true,
+			DefinitionProvider: true,
+			ReferencesProvider: true,
+			CodeActionProvider: true,
+			CompletionProvider: map[string]any{},
+		},
+		ServerInfo: ServerInfo{
+			Name:    "lsp",
+			Version: "0.0.1-beta1.final",
 		},
 	}
 }
-
-
-
-
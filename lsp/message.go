@@ -1,9 +1,11 @@
 package lsp
 
+import "fmt"
+
 // we send a response for each request type 
 type Request struct {
 	RPC string `json:"jsonrpc"`
-	ID int `json:"id"`
+	ID ID `json:"id"`
 	Method string `json:"method"`
 
 	//TODO specify the params in all request types
@@ -11,13 +13,57 @@ type Request struct {
 
 type Response struct {
 	RPC string `json:"jsonrpc"`
-	ID *int `json:"id,omitempty"` // id could be empty here
+	ID ID `json:"id"` // null when we couldn't even figure out which request this is for
 
-	// some result TODO
-	// some error TODO
+	// some result, each response type adds its own
+	Error *ResponseError `json:"error,omitempty"`
 }
 
 type Notification struct {
 	RPC string `json:"jsonrpc"`
 	Method string `json:"method"`
 }
+
+type ErrorCode int
+
+// error codes from the json-rpc 2.0 spec and the lsp spec
+const (
+	ParseError     ErrorCode = -32700
+	InvalidRequest ErrorCode = -32600
+	MethodNotFound ErrorCode = -32601
+	InvalidParams  ErrorCode = -32602
+	InternalError  ErrorCode = -32603
+
+	// the client sent a request before initialize
+	ServerNotInitialized ErrorCode = -32002
+
+	RequestCancelled ErrorCode = -32800
+	// the document changed while we were working on the request
+	ContentModified ErrorCode = -32801
+)
+
+type ResponseError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Data    any       `json:"data,omitempty"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+func NewResponseError(code ErrorCode, format string, args ...any) *ResponseError {
+	return &ResponseError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// a response that carries an error instead of a result
+func NewErrorResponse(id ID, err *ResponseError) Response {
+	return Response{
+		RPC:   "2.0",
+		ID:    id,
+		Error: err,
+	}
+}
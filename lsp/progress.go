@@ -0,0 +1,17 @@
+package lsp
+
+// a progress token is a number or a string picked by the client, which is exactly what an ID is
+type ProgressToken = ID
+
+type PartialResultParams struct {
+	// set if the client wants the result in pieces, sent with $/progress as they are found. the
+	// response itself is empty then
+	PartialResultToken *ProgressToken `json:"partialResultToken,omitempty"`
+}
+
+// ProgressParams is a $/progress notification, for partial results value is the next batch of
+// whatever the request returns
+type ProgressParams struct {
+	Token ProgressToken `json:"token"`
+	Value any           `json:"value"`
+}
@@ -40,9 +40,18 @@ type TextDocumentPositionParams struct {
 
 type Position struct {
 	Line      int `json:"line"`
-	Character int `json:"character"`
+	Character int `json:"character"` // counted in whatever PositionEncodingKind we agreed on, not bytes
 }
 
+// what a Position's Character counts
+type PositionEncodingKind string
+
+const (
+	UTF8  PositionEncodingKind = "utf-8"  // bytes
+	UTF16 PositionEncodingKind = "utf-16" // utf-16 code units, the default every client has to support
+	UTF32 PositionEncodingKind = "utf-32" // unicode code points
+)
+
 type Location struct {
 	URI   string `json:"uri"`
 	Range Range  `json:"range"`
@@ -54,10 +63,34 @@ type Range struct {
 }
 
 type WorkspaceEdit struct {
-	Changes map[string][]TextEdit `json:"changes"`
+	Changes map[string][]TextEdit `json:"changes,omitempty"`
+	// used instead of Changes when the client supports it, it pins each edit to a document version
+	DocumentChanges []TextDocumentEdit `json:"documentChanges,omitempty"`
+}
+
+type TextDocumentEdit struct {
+	TextDocument OptionalVersionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []TextEdit                              `json:"edits"`
+}
+
+type OptionalVersionedTextDocumentIdentifier struct {
+	TextDocumentIdentifier
+	Version *int `json:"version"` // null means the client should apply it to whatever it has
 }
 
 type TextEdit struct {
 	Range   Range  `json:"range"`
 	NewText string `json:"newText"`
 }
+
+type MarkupKind string
+
+const (
+	PlainText MarkupKind = "plaintext"
+	Markdown  MarkupKind = "markdown"
+)
+
+type MarkupContent struct {
+	Kind  MarkupKind `json:"kind"`
+	Value string     `json:"value"`
+}
@@ -11,21 +11,24 @@ type TextDocumentCodeActionParams struct {
 	Context      CodeActionContext      `json:"context"`
 }
 
-type TextDocumentCodeActionResponse struct {
-	Response
-	Result []CodeAction `json:"result"`
-}
-
 type CodeActionContext struct {
 	// Add fields for CodeActionContext as needed
 }
 
 type CodeAction struct {
 	Title   string         `json:"title"`
+	Kind    CodeActionKind `json:"kind,omitempty"`
 	Edit    *WorkspaceEdit `json:"edit,omitempty"`
 	Command *Command       `json:"command,omitempty"`
+
+	// what a quick fix fixes
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
 }
 
+type CodeActionKind string
+
+const QuickFix CodeActionKind = "quickfix"
+
 type Command struct {
 	Title     string        `json:"title"`
 	Command   string        `json:"command"`
@@ -9,15 +9,19 @@ type CompletionParams struct {
 	TextDocumentPositionParams
 }
 
-type CompletionResponse struct {
-	Response
-	Result []CompletionItem `json:"result"`
-}
-
 type CompletionItem struct {
 	Label         string `json:"label"`
 	Detail        string `json:"detail"`
 	Documentation string `json:"documentation"`
 
-	//TODO add more features like insertText, etc
+	InsertText       string           `json:"insertText,omitempty"`
+	InsertTextFormat InsertTextFormat `json:"insertTextFormat,omitempty"`
 }
+
+type InsertTextFormat int
+
+const (
+	PlainTextFormat InsertTextFormat = 1
+	// $1, ${1:placeholder} and $0 tab stops, only if the client said it has snippetSupport
+	SnippetFormat InsertTextFormat = 2
+)
@@ -1,5 +1,7 @@
 package lsp
 
+import "encoding/json"
+
 // no requests here we simply push this notification
 type PublishDiagnosticsNotification struct {
 	Notification
@@ -8,12 +10,103 @@ type PublishDiagnosticsNotification struct {
 
 type PublishDiagnosticsParams struct {
 	URI string `json:"uri"`
+	Version *int `json:"version,omitempty"` // the document version the diagnostics were computed for
 	Diagnostics []Diagnostic `json:"diagnostics"`
 }
 
 type Diagnostic struct {
-	Range Range `json:"range"`
-	Severity int `json:"severity"`
-	Source string `json:"source"`
-	Message string `json:"message"`
+	Range    Range              `json:"range"`
+	Severity DiagnosticSeverity `json:"severity"`
+
+	// which rule this came from, with a link to what the rule is about
+	Code            string           `json:"code,omitempty"`
+	CodeDescription *CodeDescription `json:"codeDescription,omitempty"`
+
+	Source  string          `json:"source"`
+	Message string          `json:"message"`
+	Tags    []DiagnosticTag `json:"tags,omitempty"`
+
+	// other places that have something to do with this one
+	RelatedInformation []DiagnosticRelatedInformation `json:"relatedInformation,omitempty"`
+
+	// ours, the client hands it back untouched in codeAction requests
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type DiagnosticSeverity int
+
+const (
+	SeverityError       DiagnosticSeverity = 1
+	SeverityWarning     DiagnosticSeverity = 2
+	SeverityInformation DiagnosticSeverity = 3
+	SeverityHint        DiagnosticSeverity = 4
+)
+
+type CodeDescription struct {
+	Href string `json:"href"`
+}
+
+type DiagnosticTag int
+
+const (
+	// unused or unnecessary code, editors fade it out
+	TagUnnecessary DiagnosticTag = 1
+	// deprecated or obsolete, editors strike it through
+	TagDeprecated DiagnosticTag = 2
+)
+
+type DiagnosticRelatedInformation struct {
+	Location Location `json:"location"`
+	Message  string   `json:"message"`
+}
+
+// pull diagnostics (lsp 3.17), the client asks instead of us pushing them
+
+type DiagnosticOptions struct {
+	Identifier            string `json:"identifier,omitempty"`
+	InterFileDependencies bool   `json:"interFileDependencies"`
+	WorkspaceDiagnostics  bool   `json:"workspaceDiagnostics"`
+}
+
+type DocumentDiagnosticParams struct {
+	TextDocument     TextDocumentIdentifier `json:"textDocument"`
+	Identifier       string                 `json:"identifier,omitempty"`
+	PreviousResultID string                 `json:"previousResultId,omitempty"`
+}
+
+type DocumentDiagnosticReportKind string
+
+const (
+	// a new list of diagnostics
+	ReportFull DocumentDiagnosticReportKind = "full"
+	// nothing changed since previousResultId, the client keeps what it has
+	ReportUnchanged DocumentDiagnosticReportKind = "unchanged"
+)
+
+// full and unchanged reports share this. Items is nil for unchanged ones so it gets left out,
+// a full report always has a (maybe empty) list
+type DocumentDiagnosticReport struct {
+	Kind     DocumentDiagnosticReportKind `json:"kind"`
+	ResultID string                       `json:"resultId,omitempty"`
+	Items    []Diagnostic                 `json:"items,omitzero"`
+}
+
+type WorkspaceDiagnosticParams struct {
+	Identifier        string             `json:"identifier,omitempty"`
+	PreviousResultIDs []PreviousResultID `json:"previousResultIds"`
+}
+
+type PreviousResultID struct {
+	URI   string `json:"uri"`
+	Value string `json:"value"`
+}
+
+type WorkspaceDiagnosticReport struct {
+	Items []WorkspaceDocumentDiagnosticReport `json:"items"`
+}
+
+type WorkspaceDocumentDiagnosticReport struct {
+	DocumentDiagnosticReport
+	URI     string `json:"uri"`
+	Version *int   `json:"version"` // null for files that aren't open
 }
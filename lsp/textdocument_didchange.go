@@ -15,6 +15,13 @@ type DidChangeTextDocumentParams struct {
  * it is considered to be the full content of the document.
  */
 type TextDocumentContentChangeEvent struct {
-	// The new text of the whole document.
+	// The range of the document that changed. Missing when Text is the whole document.
+	Range *Range `json:"range,omitempty"`
+
+	// The optional length of the range that got replaced.
+	// Deprecated: use Range instead, we only ever look at Range.
+	RangeLength *int `json:"rangeLength,omitempty"`
+
+	// The new text for the provided range, or of the whole document.
 	Text string `json:"text"`
 }
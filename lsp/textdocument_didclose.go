@@ -0,0 +1,12 @@
+// this is used when we get the didclose text doc notification
+
+package lsp
+
+type DidCloseTextDocumentNotification struct {
+	Notification
+	Params DidCloseTextDocumentParams `json:"params"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
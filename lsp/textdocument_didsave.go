@@ -0,0 +1,33 @@
+// didsave and willsavewaituntil, both are about the document hitting the disk
+
+package lsp
+
+type DidSaveTextDocumentNotification struct {
+	Notification
+	Params DidSaveTextDocumentParams `json:"params"`
+}
+
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+
+	// only there because we asked for it with Save.IncludeText
+	Text *string `json:"text,omitempty"`
+}
+
+type WillSaveWaitUntilTextDocumentRequest struct {
+	Request
+	Params WillSaveTextDocumentParams `json:"params"`
+}
+
+type WillSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Reason       TextDocumentSaveReason `json:"reason"`
+}
+
+type TextDocumentSaveReason int
+
+const (
+	SaveManual     TextDocumentSaveReason = 1
+	SaveAfterDelay TextDocumentSaveReason = 2
+	SaveFocusOut   TextDocumentSaveReason = 3
+)
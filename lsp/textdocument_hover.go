@@ -9,11 +9,7 @@ type HoverParams struct {
 	TextDocumentPositionParams
 }
 
-type HoverResponse struct {
-	Response
-	Result HoverResult `json:"result"`
-}
-
 type HoverResult struct {
-	Contents string `json:"contents"`
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"` // what the hover is about, editors highlight it
 }
@@ -0,0 +1,17 @@
+package lsp
+
+type ReferenceRequest struct {
+	Request
+	Params ReferenceParams `json:"params"`
+}
+
+type ReferenceParams struct {
+	TextDocumentPositionParams
+	PartialResultParams
+	Context ReferenceContext `json:"context"`
+}
+
+type ReferenceContext struct {
+	// the heading or definition the references are to counts as one too
+	IncludeDeclaration bool `json:"includeDeclaration"`
+}
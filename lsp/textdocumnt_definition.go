@@ -1,5 +1,7 @@
 package lsp
 
+import "encoding/json"
+
 type DefinitionRequest struct {
 	Request
 	Params DefinitionParams `json:"params"`
@@ -9,7 +11,35 @@ type DefinitionParams struct {
 	TextDocumentPositionParams
 }
 
-type DefinitionResponse struct {
-	Response
-	Result Location `json:"result"`
+// LocationLink is a Location that also knows where it was found from and which part of the
+// target is the interesting bit
+type LocationLink struct {
+	// what the editor underlines in the document the request came from, like the whole link
+	OriginSelectionRange *Range `json:"originSelectionRange,omitempty"`
+
+	TargetURI            string `json:"targetUri"`
+	TargetRange          Range  `json:"targetRange"`          // all of the target, like a whole section
+	TargetSelectionRange Range  `json:"targetSelectionRange"` // the part to reveal inside it, like the heading
+}
+
+// DefinitionResult goes out as a list of LocationLinks to clients that said they take them and
+// as plain Locations to everyone else
+type DefinitionResult struct {
+	Links       []LocationLink
+	LinkSupport bool
+}
+
+func (r DefinitionResult) MarshalJSON() ([]byte, error) {
+	if r.Links == nil {
+		r.Links = []LocationLink{}
+	}
+	if r.LinkSupport {
+		return json.Marshal(r.Links)
+	}
+
+	locations := make([]Location, len(r.Links))
+	for i, link := range r.Links {
+		locations[i] = Location{URI: link.TargetURI, Range: link.TargetSelectionRange}
+	}
+	return json.Marshal(locations)
 }
@@ -1,159 +1,274 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
-	"io"
+	"context"
+	"fmt"
 	"log"
 	"lsp/compiler"
 	"lsp/lsp"
-	"lsp/rpc"
+	"lsp/router"
 	"os"
+	"time"
 )
 
 func main() {
 	logger := getLogger("/Users/shiven/Developer/lsp/log.txt")
 	logger.Println("Logger Started!")
 
-	scanner := bufio.NewScanner(os.Stdin) // reading input from stdin
-	scanner.Split(rpc.Split)              // now this is going to be waiting on stdout for the new msg and once we get that the scanner will give use the text and then we can handle it below
-
-	state := compiler.NewState()	
-	writer := os.Stdout
-
-	for scanner.Scan() { // basically saying keep on running it until we are ready
-		msg := scanner.Bytes()
-		method, contents, err := rpc.DecodeMessage(msg)
-		if err != nil {
-			logger.Panicf("got an error: %s", err)
-			continue
+	state := compiler.NewState()
+	life := newLifecycle(logger, os.Exit)
+	conn := router.NewConn(os.Stdin, os.Stdout, logger) // reading input from stdin, one framed message at a time no matter how big it is
+	sess := &session{}
+	diagnostics := newDiagnosticsScheduler(logger, state, defaultDiagnosticsDelay, func(uri string, version int, diagnostics []lsp.Diagnostic) {
+		if sess.pullDiagnostics() {
+			return // the client asks with textDocument/diagnostic when it wants them
 		}
-		HandleMessage(logger, writer, state, method, contents)
+		publishDiagnostics(conn, uri, version, diagnostics)
+	})
+	rt := newRouter(logger, conn, state, life, sess, diagnostics)
+
+	if err := conn.Run(context.Background(), rt); err != nil {
+		logger.Printf("lost track of the message stream: %s", err)
+	} else {
+		logger.Print("stdin closed, bye")
 	}
+	os.Exit(life.exitCode())
 }
 
-func HandleMessage(logger *log.Logger, writer io.Writer, state compiler.State, method string, contents []byte) {
-	logger.Printf("received message with method: %s", method) // this just makes sure everytime we get a message we print the message, lets us know are we decoding msg and passing them forward correctly
-	
-	switch method {
-		case "initialize": 
-			var request lsp.InitializeRequest
-			if err:= json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("hey, couldn't parse this: %s", err)
-			}
-			logger.Printf("Connected to: %s %s", request.Params.ClientInfo.Name, request.Params.ClientInfo.Version)
-
-			// once we know we have a message i.e a request our lsp should reply
-			msg := lsp.NewInitializeResponse(request.ID)
-
-			writeResponse(writer, msg)
-			
-			// debugging
-			// logger.Printf("initialize response: %s", string(reply))	
-			
-			logger.Print("sent the reply")
-
-		case "textDocument/didOpen":
-			var request lsp.DidOpenTextDocumentNotification
-			if err:= json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("textDocument/didOpen: %s", err)
-			return
+// every method the server understands gets registered here
+func newRouter(logger *log.Logger, conn *router.Conn, state *compiler.State, life *lifecycle, sess *session, diagnostics *diagnosticsScheduler) *router.Router {
+	rules := newRulesFile(logger, conn, state, sess, diagnostics)
+
+	rt := router.New()
+	rt.Use(
+		router.Logging(logger), // this just makes sure everytime we get a message we print it, lets us know are we decoding msg and passing them forward correctly
+		router.Timing(func(method string, elapsed time.Duration) {
+			logger.Printf("timing method=%s elapsed=%s", method, elapsed)
+		}),
+		life.middleware(),
+		router.Recover(logger), // innermost so the logging above still sees the InternalError
+	)
+
+	router.Handle(rt, "initialize", func(ctx context.Context, params lsp.InitializeRequestParams) (lsp.InitializeResult, error) {
+		if params.ClientInfo != nil {
+			logger.Printf("Connected to: %s %s", params.ClientInfo.Name, params.ClientInfo.Version)
+		}
+
+		sess.initialize(params)
+		life.initialized(params.ProcessID)
+
+		encoding := compiler.NegotiateEncoding(params.Capabilities.PositionEncodings())
+		state.SetPositionEncoding(encoding)
+		logger.Printf("position encoding: %s", encoding)
+		state.SetWorkspaceFolders(params.Folders())
+
+		if options := params.InitializationOptions; options != nil {
+			if options.DiagnosticsDelay != nil {
+				diagnostics.setDelay(time.Duration(*options.DiagnosticsDelay) * time.Millisecond)
 			}
-			// logger.Printf("Opened: %s %s", request.Params.TextDocument.URI, request.Params.TextDocument.Text)
-			logger.Printf("Opened: %s", request.Params.TextDocument.URI)
-			
-			diagnostics := state.OpenDocument(request.Params.TextDocument.URI, request.Params.TextDocument.Text)
-			writeResponse(writer, lsp.PublishDiagnosticsNotification{
-				Notification: lsp.Notification{
-					RPC:    "2.0",
-					Method: "textDocument/publishDiagnostics",
-				},
-				Params: lsp.PublishDiagnosticsParams{
-					URI:         request.Params.TextDocument.URI,
-					Diagnostics: diagnostics,
-				},
-			})
-		
-		case "textDocument/didChange":
-			var request lsp.TextDocumentDidChangeNotification
-			if err:= json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("textDocument/didChange: %s", err)
-			return
+			if options.RulesFile != nil {
+				rules.open(*options.RulesFile)
 			}
-			logger.Printf("Changed: %s", request.Params.TextDocument.URI)
-			for _, change := range request.Params.ContentChanges{
-				
-			diagnostics := state.UpdateDocument(request.Params.TextDocument.URI, change.Text)
-			writeResponse(writer, lsp.PublishDiagnosticsNotification{
-				Notification: lsp.Notification{
-					RPC:    "2.0",
-					Method: "textDocument/publishDiagnostics",
-				},
-				Params: lsp.PublishDiagnosticsParams{
-					URI:         request.Params.TextDocument.URI,
-					Diagnostics: diagnostics,
-				},
-			})
 		}
 
-		case "textDocument/hover":
-			var request lsp.HoverRequest
-			if err:= json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("textDocument/hover: %s", err)
-			return
+		// once we know we have a message i.e a request our lsp should reply
+		result := lsp.NewInitializeResult()
+		result.Capabilities.PositionEncoding = encoding
+		if params.Capabilities.SupportsPullDiagnostics() {
+			result.Capabilities.DiagnosticProvider = &lsp.DiagnosticOptions{
+				InterFileDependencies: false, // every rule only looks at its own file
+				WorkspaceDiagnostics:  true,
 			}
-			
-			// create a response and write it back
-			response := state.Hover(request.ID, request.Params.TextDocument.URI, request.Params.Position) // creating a response
+		}
+		return result, nil
+	})
 
-			writeResponse(writer, response) // writing it back
+	router.Notify(rt, "initialized", func(ctx context.Context, params struct{}) error {
+		logger.Print("client finished initializing")
+		rules.watch()
+		return nil
+	})
 
-		case "textDocument/definition":
-			var request lsp.DefinitionRequest
-			if err:= json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("textDocument/definition: %s", err)
-			return
-			}
-			
-			// create a response and write it back
-			response := state.Definition(request.ID, request.Params.TextDocument.URI, request.Params.Position) // creating a response
-
-			writeResponse(writer, response) // writing it back
-			
-		case "textDocument/codeAction":
-			var request lsp.DefinitionRequest
-			if err:= json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("textDocument/codeAction: %s", err)
-			return
+	router.Handle(rt, "shutdown", func(ctx context.Context, params struct{}) (*struct{}, error) {
+		life.shutdown()
+		return nil, nil // the result has to be null
+	})
+
+	router.Notify(rt, "exit", func(ctx context.Context, params struct{}) error {
+		code := life.exitCode()
+		logger.Printf("exit with code %d", code)
+		life.exit(code)
+		return nil
+	})
+
+	router.Notify(rt, "textDocument/didOpen", func(ctx context.Context, params lsp.DidOpenTextDocumentParams) error {
+		logger.Printf("Opened: %s", params.TextDocument.URI)
+
+		snapshot := state.OpenDocument(params.TextDocument.URI, params.TextDocument.Version, params.TextDocument.Text)
+		if rules.is(snapshot.URI) {
+			rules.load(snapshot.Text)
+		}
+		diagnostics.publishNow(snapshot, state.Diagnostics(snapshot))
+		return nil
+	})
+
+	router.Notify(rt, "textDocument/didChange", func(ctx context.Context, params lsp.DidChangeTextDocumentParams) error {
+		logger.Printf("Changed: %s", params.TextDocument.URI)
+
+		snapshot, err := state.ChangeDocument(params.TextDocument.URI, params.TextDocument.Version, params.ContentChanges)
+		if err != nil {
+			return err
+		}
+		if rules.is(snapshot.URI) {
+			rules.load(snapshot.Text) // the buffer wins over the disk while it is open
+		}
+
+		// people type fast, wait for the document to settle before checking it
+		diagnostics.schedule(params.TextDocument.URI)
+		return nil
+	})
+
+	router.Notify(rt, "textDocument/didClose", func(ctx context.Context, params lsp.DidCloseTextDocumentParams) error {
+		logger.Printf("Closed: %s", params.TextDocument.URI)
+
+		state.CloseDocument(params.TextDocument.URI)
+		diagnostics.forget(params.TextDocument.URI)
+		if rules.is(params.TextDocument.URI) {
+			rules.loadFromDisk() // whatever wasn't saved is gone
+		}
+
+		// nobody is looking at it anymore so its diagnostics shouldn't hang around in the editor.
+		// pulled ones are the client's business
+		if sess.pullDiagnostics() {
+			return nil
+		}
+		conn.Notify("textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []lsp.Diagnostic{},
+		})
+		return nil
+	})
+
+	router.Notify(rt, "textDocument/didSave", func(ctx context.Context, params lsp.DidSaveTextDocumentParams) error {
+		logger.Printf("Saved: %s", params.TextDocument.URI)
+
+		snapshot, ok := state.Snapshot(params.TextDocument.URI)
+		if !ok {
+			return fmt.Errorf("document not open: %s", params.TextDocument.URI)
+		}
+		if params.Text != nil && *params.Text != snapshot.Text {
+			logger.Printf("saved text of %s doesn't match version %d, we missed a change somewhere", snapshot.URI, snapshot.Version)
+		}
+
+		diagnostics.publishNow(snapshot, state.SaveDocument(snapshot))
+		return nil
+	})
+
+	router.Notify(rt, "workspace/didChangeWatchedFiles", func(ctx context.Context, params lsp.DidChangeWatchedFilesParams) error {
+		for _, change := range params.Changes {
+			if _, open := state.Snapshot(change.URI); rules.is(change.URI) && !open {
+				logger.Printf("rules file changed on disk: %s", change.URI)
+				rules.loadFromDisk()
 			}
-			
-			// create a response and write it back
-			response := state.TextDocumentCodeAction(request.ID, request.Params.TextDocument.URI) // creating a response
-
-			writeResponse(writer, response) // writing it back
-		
-		case "textDocument/completion":
-			var request lsp.CompletionRequest
-			if err:= json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("textDocument/completion: %s", err)
-			return
+		}
+		return nil
+	})
+
+	router.Handle(rt, "textDocument/willSaveWaitUntil", func(ctx context.Context, params lsp.WillSaveTextDocumentParams) ([]lsp.TextEdit, error) {
+		return withSnapshot(state, params.TextDocument.URI, func(snapshot *compiler.Snapshot) []lsp.TextEdit {
+			return state.WillSaveWaitUntil(snapshot)
+		})
+	})
+
+	// every request works on one snapshot so it sees a consistent document even if edits arrive meanwhile
+	router.Handle(rt, "textDocument/hover", func(ctx context.Context, params lsp.HoverParams) (lsp.HoverResult, error) {
+		return withSnapshot(state, params.TextDocument.URI, func(snapshot *compiler.Snapshot) lsp.HoverResult {
+			return state.Hover(snapshot, params.Position, sess.client())
+		})
+	})
+
+	router.Handle(rt, "textDocument/definition", func(ctx context.Context, params lsp.DefinitionParams) (lsp.DefinitionResult, error) {
+		return withSnapshot(state, params.TextDocument.URI, func(snapshot *compiler.Snapshot) lsp.DefinitionResult {
+			return lsp.DefinitionResult{
+				Links:       state.Definition(snapshot, params.Position),
+				LinkSupport: sess.client().SupportsDefinitionLinks(),
 			}
-			
-			// create a response and write it back
-			response := state.TextDocumentCompletion(request.ID, request.Params.TextDocument.URI) // creating a response
-
-			writeResponse(writer, response) // writing it back
-			// debugging
-			// logger.Printf("raw contents: %s", string(contents))
-		
-	}
+		})
+	})
+
+	// looks through the whole workspace, with a partial result token the client gets each
+	// document's references as soon as they are found instead of waiting for all of them
+	router.Handle(rt, "textDocument/references", func(ctx context.Context, params lsp.ReferenceParams) ([]lsp.Location, error) {
+		return withSnapshot(state, params.TextDocument.URI, func(snapshot *compiler.Snapshot) []lsp.Location {
+			locations := []lsp.Location{}
+			state.References(ctx, snapshot, params.Position, params.Context.IncludeDeclaration, func(found []lsp.Location) {
+				if params.PartialResultToken == nil {
+					locations = append(locations, found...)
+					return
+				}
+				if err := conn.Notify("$/progress", lsp.ProgressParams{Token: *params.PartialResultToken, Value: found}); err != nil {
+					logger.Printf("couldn't send references: %s", err)
+				}
+			})
+			return locations
+		})
+	})
+
+	router.Handle(rt, "textDocument/codeAction", func(ctx context.Context, params lsp.TextDocumentCodeActionParams) ([]lsp.CodeAction, error) {
+		return withSnapshot(state, params.TextDocument.URI, func(snapshot *compiler.Snapshot) []lsp.CodeAction {
+			return state.TextDocumentCodeAction(snapshot, sess.client())
+		})
+	})
+
+	router.Handle(rt, "textDocument/completion", func(ctx context.Context, params lsp.CompletionParams) ([]lsp.CompletionItem, error) {
+		return withSnapshot(state, params.TextDocument.URI, func(snapshot *compiler.Snapshot) []lsp.CompletionItem {
+			return state.TextDocumentCompletion(snapshot, sess.client())
+		})
+	})
+
+	// pull diagnostics, same checks as the pushed ones. a result id the client already has for
+	// this version gets an "unchanged" report instead of the whole list again
+	router.Handle(rt, "textDocument/diagnostic", func(ctx context.Context, params lsp.DocumentDiagnosticParams) (lsp.DocumentDiagnosticReport, error) {
+		return withSnapshot(state, params.TextDocument.URI, func(snapshot *compiler.Snapshot) lsp.DocumentDiagnosticReport {
+			return state.DocumentDiagnostics(snapshot, params.PreviousResultID)
+		})
+	})
+
+	router.Handle(rt, "workspace/diagnostic", func(ctx context.Context, params lsp.WorkspaceDiagnosticParams) (lsp.WorkspaceDiagnosticReport, error) {
+		previous := map[string]string{}
+		for _, id := range params.PreviousResultIDs {
+			previous[id.URI] = id.Value
+		}
+		// we only know about open documents, there is no reading the workspace from disk (yet)
+		return state.WorkspaceDiagnostics(previous), nil
+	})
+
+	return rt
 }
 
+// withSnapshot runs fn on the latest snapshot of uri. if the document changed while fn was running
+// the result points at text that isn't there anymore, so the client gets ContentModified instead
+func withSnapshot[R any](state *compiler.State, uri string, fn func(snapshot *compiler.Snapshot) R) (R, error) {
+	var zero R
+
+	snapshot, ok := state.Snapshot(uri)
+	if !ok {
+		return zero, lsp.NewResponseError(lsp.InvalidParams, "document not open: %s", uri)
+	}
 
+	result := fn(snapshot)
+	if !state.IsCurrent(snapshot) {
+		return zero, lsp.NewResponseError(lsp.ContentModified, "%s changed while handling the request", uri)
+	}
+	return result, nil
+}
 
-func writeResponse(writer io.Writer, msg any) {
-	reply := rpc.EncodeMessage(msg)
-	writer.Write([]byte(reply)) // this is going to take the stdout of the current process and reply back with this a sequence of bytes
+func publishDiagnostics(conn *router.Conn, uri string, version int, diagnostics []lsp.Diagnostic) {
+	conn.Notify("textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     &version,
+		Diagnostics: diagnostics,
+	})
 }
 
 func getLogger(filename string) *log.Logger {
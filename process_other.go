@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// on windows FindProcess actually opens the process so it fails once it is gone
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// signal 0 does nothing but still checks if the process exists. EPERM means it exists,
+// it just isn't ours
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
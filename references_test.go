@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"lsp/compiler"
+	"lsp/lsp"
+	"lsp/router"
+	"strings"
+	"testing"
+)
+
+func TestReferencesStreamPartialResults(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	state := compiler.NewState()
+	var sent bytes.Buffer
+	conn := router.NewConn(nil, &sent, logger)
+	diagnostics := newDiagnosticsScheduler(logger, state, 0, func(uri string, version int, diagnostics []lsp.Diagnostic) {})
+	rt := newRouter(logger, conn, state, newLifecycle(logger, func(int) {}), &session{}, diagnostics)
+
+	rt.Dispatch(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	state.OpenDocument("file:///a.md", 1, "[x][a] [y][a]\n\n[a]: https://x.y\n")
+
+	reply, err := json.Marshal(rt.Dispatch(context.Background(), []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/references","params":{
+		"textDocument":{"uri":"file:///a.md"},"position":{"line":2,"character":1},
+		"context":{"includeDeclaration":true},"partialResultToken":"refs"}}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(reply), `"result":[]`) {
+		t.Fatalf("Expected: an empty result once everything went out as progress, Got: %s", reply)
+	}
+
+	progress := sent.String()
+	if !strings.Contains(progress, `"method":"$/progress"`) || !strings.Contains(progress, `"token":"refs"`) || strings.Count(progress, `"uri":"file:///a.md"`) != 3 {
+		t.Fatalf("Expected: the definition and both uses in $/progress, Got: %s", progress)
+	}
+}
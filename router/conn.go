@@ -0,0 +1,241 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"lsp/lsp"
+	"lsp/rpc"
+	"sync"
+)
+
+// returned by Call when the stream ends before the client answers
+var ErrClosed = errors.New("router: connection closed")
+
+// Conn serves a Router over a stream. requests run on their own goroutine so a slow one
+// doesn't block everything after it, notifications run in the read loop so document sync is
+// always applied in the order it arrives
+type Conn struct {
+	reader *rpc.Reader
+	logger *log.Logger
+
+	writeMu sync.Mutex // only one message on the wire at a time
+	writer  io.Writer
+
+	mu       sync.Mutex
+	inflight map[lsp.ID]context.CancelFunc // requests that are still running, for $/cancelRequest
+	wg       sync.WaitGroup
+
+	// requests we sent to the client that are waiting for an answer
+	nextID  int64
+	pending map[lsp.ID]chan *clientResponse
+	closed  bool
+}
+
+func NewConn(r io.Reader, w io.Writer, logger *log.Logger) *Conn {
+	return &Conn{
+		reader:   rpc.NewReader(r),
+		writer:   w,
+		logger:   logger,
+		inflight: map[lsp.ID]context.CancelFunc{},
+		pending:  map[lsp.ID]chan *clientResponse{},
+	}
+}
+
+// Write encodes msg and sends it, safe to call from any goroutine
+func (c *Conn) Write(msg any) error {
+	reply := rpc.EncodeMessage(msg)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := io.WriteString(c.writer, reply)
+	return err
+}
+
+// Run reads messages until the stream ends and dispatches them to rt. it returns nil when the
+// client closes the stream cleanly, after every running request has replied
+func (c *Conn) Run(ctx context.Context, rt *Router) error {
+	defer c.wg.Wait()
+	defer c.closePending()
+
+	for { // basically saying keep on running it until the client hangs up
+		content, err := c.reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			// a bad header or a truncated body means the stream is out of sync, there is no way to find the next message
+			return err
+		}
+
+		req, response, errResponse := decode(content)
+		if errResponse != nil { // bad json gets a ParseError back instead of killing the loop
+			c.write(*errResponse)
+			continue
+		}
+		if response != nil {
+			c.deliver(response)
+			continue
+		}
+		if req == nil {
+			continue
+		}
+
+		if req.Method == "$/cancelRequest" {
+			c.cancel(req)
+			continue
+		}
+
+		if req.IsNotification() {
+			rt.Serve(ctx, req)
+			continue
+		}
+
+		c.serveRequest(ctx, rt, req)
+	}
+}
+
+func (c *Conn) serveRequest(ctx context.Context, rt *Router, req *Request) {
+	id := *req.ID
+	reqCtx, cancel := context.WithCancel(ctx)
+
+	c.mu.Lock()
+	c.inflight[id] = cancel
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.inflight, id)
+			c.mu.Unlock()
+			cancel()
+		}()
+
+		reply := rt.Serve(reqCtx, req)
+		if reqCtx.Err() != nil { // whatever the handler came up with, the client doesn't want it anymore
+			reply = lsp.NewErrorResponse(id, lsp.NewResponseError(lsp.RequestCancelled, "request cancelled: %s", req.Method))
+		}
+		c.write(reply)
+	}()
+}
+
+func (c *Conn) cancel(req *Request) {
+	var params struct {
+		ID lsp.ID `json:"id"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		c.logger.Printf("$/cancelRequest: %s", err)
+		return
+	}
+
+	c.mu.Lock()
+	cancel, ok := c.inflight[params.ID]
+	c.mu.Unlock()
+
+	if ok { // it might have finished already, that's fine
+		cancel()
+	}
+}
+
+func (c *Conn) write(msg any) {
+	if err := c.Write(msg); err != nil {
+		c.logger.Printf("couldn't write message: %s", err)
+	}
+}
+
+// Notify sends a notification to the client
+func (c *Conn) Notify(method string, params any) error {
+	return c.Write(outgoing{
+		RPC:    "2.0",
+		Method: method,
+		Params: params,
+	})
+}
+
+// Call sends a request to the client and waits for its answer, which gets decoded into result.
+// if the client answers with an error it comes back as a *lsp.ResponseError.
+// don't call this from a notification handler, those run in the read loop that delivers the answer
+func (c *Conn) Call(ctx context.Context, method string, params any, result any) error {
+	ch := make(chan *clientResponse, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.nextID++
+	id := lsp.NewNumberID(c.nextID)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := c.Write(outgoing{RPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		c.forget(id)
+		return err
+	}
+
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			return ErrClosed
+		}
+		if response.Error != nil {
+			return response.Error
+		}
+		if result == nil || len(response.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(response.Result, result)
+
+	case <-ctx.Done():
+		// let the client know we gave up so it can stop working on it too
+		c.forget(id)
+		c.Notify("$/cancelRequest", struct {
+			ID lsp.ID `json:"id"`
+		}{ID: id})
+		return ctx.Err()
+	}
+}
+
+// a request or notification going from the server to the client
+type outgoing struct {
+	RPC    string  `json:"jsonrpc"`
+	ID     *lsp.ID `json:"id,omitempty"`
+	Method string  `json:"method"`
+	Params any     `json:"params,omitempty"`
+}
+
+// hands a response from the client to whoever is waiting in Call
+func (c *Conn) deliver(response *clientResponse) {
+	c.mu.Lock()
+	ch, ok := c.pending[response.ID]
+	delete(c.pending, response.ID)
+	c.mu.Unlock()
+
+	if !ok {
+		c.logger.Printf("got a response for id=%s but nothing is waiting for it", response.ID)
+		return
+	}
+	ch <- response
+}
+
+func (c *Conn) forget(id lsp.ID) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// once the read loop stops no answers are coming, so wake everyone still waiting
+func (c *Conn) closePending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
@@ -0,0 +1,132 @@
+package router_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"lsp/lsp"
+	"lsp/router"
+	"lsp/rpc"
+	"testing"
+)
+
+func TestConnCancelRequest(t *testing.T) {
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+	replies := rpc.NewReader(clientReader)
+
+	started := make(chan struct{})
+	rt := router.New()
+	router.Handle(rt, "slow", func(ctx context.Context, params echoParams) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "too late", nil
+	})
+	router.Handle(rt, "echo", func(ctx context.Context, params echoParams) (string, error) {
+		return params.Text, nil
+	})
+
+	conn := router.NewConn(serverReader, serverWriter, log.New(io.Discard, "", 0))
+	done := make(chan error)
+	go func() { done <- conn.Run(context.Background(), rt) }()
+
+	send := func(msg string) {
+		if _, err := io.WriteString(clientWriter, rpc.EncodeMessage(json.RawMessage(msg))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() lsp.Response {
+		content, err := replies.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply lsp.Response
+		if err := json.Unmarshal(content, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"slow"}`)
+	<-started
+
+	// the slow request must not block this one
+	send(`{"jsonrpc":"2.0","id":2,"method":"echo","params":{"text":"hi"}}`)
+	if reply := read(); reply.ID != lsp.NewNumberID(2) || reply.Error != nil {
+		t.Fatalf("Expected: a result for id 2, Got: %+v", reply)
+	}
+
+	send(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":1}}`)
+	reply := read()
+	if reply.ID != lsp.NewNumberID(1) || reply.Error == nil || reply.Error.Code != lsp.RequestCancelled {
+		t.Fatalf("Expected: RequestCancelled for id 1, Got: %+v", reply)
+	}
+
+	clientWriter.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnCall(t *testing.T) {
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+	messages := rpc.NewReader(clientReader)
+
+	conn := router.NewConn(serverReader, serverWriter, log.New(io.Discard, "", 0))
+	rt := router.New()
+	router.Handle(rt, "ask", func(ctx context.Context, params echoParams) (string, error) {
+		var answer struct {
+			Title string `json:"title"`
+		}
+		if err := conn.Call(ctx, "window/showMessageRequest", map[string]any{"message": params.Text}, &answer); err != nil {
+			return "", err
+		}
+		return answer.Title, nil
+	})
+
+	done := make(chan error)
+	go func() { done <- conn.Run(context.Background(), rt) }()
+
+	send := func(msg string) {
+		if _, err := io.WriteString(clientWriter, rpc.EncodeMessage(json.RawMessage(msg))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(`{"jsonrpc":"2.0","id":"c1","method":"ask","params":{"text":"pick one"}}`)
+
+	// the server asks us something while our request is still running
+	content, err := messages.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outgoing struct {
+		ID     lsp.ID `json:"id"`
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(content, &outgoing); err != nil {
+		t.Fatal(err)
+	}
+	if outgoing.Method != "window/showMessageRequest" || outgoing.ID.IsNull() {
+		t.Fatalf("Expected: a window/showMessageRequest with an id, Got: %s", content)
+	}
+
+	answer, _ := json.Marshal(outgoing.ID)
+	send(`{"jsonrpc":"2.0","id":` + string(answer) + `,"result":{"title":"Neovim"}}`)
+
+	content, err = messages.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"jsonrpc":"2.0","id":"c1","result":"Neovim"}`
+	if string(content) != expected {
+		t.Fatalf("Expected: %s, Actual: %s", expected, content)
+	}
+
+	clientWriter.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"context"
+	"log"
+	"lsp/lsp"
+	"runtime/debug"
+	"time"
+)
+
+// a middleware wraps a handler to do something before and/or after it runs
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use adds middleware to every request and notification going through the router.
+// the first one added is the outermost, so it sees the request first and the result last
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+func (r *Router) chain(handler HandlerFunc) HandlerFunc {
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+	return handler
+}
+
+// Recover turns a panic in a handler into an InternalError instead of taking the whole server down
+func Recover(logger *log.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (result any, err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					logger.Printf("panic in %s: %v\n%s", req.Method, p, debug.Stack())
+					result = nil
+					err = lsp.NewResponseError(lsp.InternalError, "internal error in %s: %v", req.Method, p)
+				}
+			}()
+			return next(ctx, req)
+		}
+	}
+}
+
+// Timing calls report with how long each method took, hook it up to a logger or some metrics
+func Timing(report func(method string, elapsed time.Duration)) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (any, error) {
+			start := time.Now()
+			result, err := next(ctx, req)
+			report(req.Method, time.Since(start))
+			return result, err
+		}
+	}
+}
+
+// Logging writes one line for every message coming in and one for every reply going out,
+// as key=value pairs so they are easy to grep
+func Logging(logger *log.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (any, error) {
+			kind := "request"
+			id := "-"
+			if req.IsNotification() {
+				kind = "notification"
+			} else {
+				id = req.ID.String()
+			}
+			logger.Printf("recv kind=%s method=%s id=%s params_bytes=%d", kind, req.Method, id, len(req.Params))
+
+			result, err := next(ctx, req)
+
+			if err != nil {
+				logger.Printf("done kind=%s method=%s id=%s error=%q", kind, req.Method, id, err.Error())
+			} else {
+				logger.Printf("done kind=%s method=%s id=%s", kind, req.Method, id)
+			}
+
+			return result, err
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// the router maps method names to handlers so main doesn't need a giant switch.
+// decoding params, error replies and the request vs notification split all happen here
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"lsp/lsp"
+)
+
+// the parts of an incoming json-rpc message the router cares about
+type Request struct {
+	ID     *lsp.ID // nil for notifications
+	Method string
+	Params json.RawMessage
+}
+
+func (r *Request) IsNotification() bool {
+	return r.ID == nil
+}
+
+// a handler gets the raw request and returns the result to send back.
+// the result is ignored for notifications
+type HandlerFunc func(ctx context.Context, req *Request) (any, error)
+
+type Router struct {
+	handlers   map[string]HandlerFunc
+	middleware []Middleware
+}
+
+func New() *Router {
+	return &Router{handlers: map[string]HandlerFunc{}}
+}
+
+// Handle registers a request handler, params get decoded into P and the R that comes back
+// is sent as the result. returning a *lsp.ResponseError picks the error code, any other error
+// becomes an InternalError
+func Handle[P, R any](r *Router, method string, fn func(ctx context.Context, params P) (R, error)) {
+	r.handlers[method] = func(ctx context.Context, req *Request) (any, error) {
+		params, err := decodeParams[P](req)
+		if err != nil {
+			return nil, err
+		}
+		return fn(ctx, params)
+	}
+}
+
+// Notify registers a notification handler, there is nothing to send back so it only returns an error
+func Notify[P any](r *Router, method string, fn func(ctx context.Context, params P) error) {
+	r.handlers[method] = func(ctx context.Context, req *Request) (any, error) {
+		params, err := decodeParams[P](req)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fn(ctx, params)
+	}
+}
+
+func decodeParams[P any](req *Request) (P, error) {
+	var params P
+	if len(req.Params) == 0 { // some methods like shutdown have no params at all
+		return params, nil
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return params, lsp.NewResponseError(lsp.InvalidParams, "%s: %s", req.Method, err)
+	}
+	return params, nil
+}
+
+// Dispatch decodes a message, runs its handler and returns the reply that should be written
+// back to the client. for notifications the reply is nil. responses from the client are dropped,
+// use a Conn if the server needs to send requests of its own
+func (r *Router) Dispatch(ctx context.Context, content []byte) any {
+	req, errResponse := DecodeRequest(content)
+	if errResponse != nil {
+		return *errResponse
+	}
+	if req == nil {
+		return nil
+	}
+
+	return r.Serve(ctx, req)
+}
+
+// Serve runs the handler for an already decoded request, wrapped in all the middleware
+func (r *Router) Serve(ctx context.Context, req *Request) any {
+	result, err := r.chain(r.lookup)(ctx, req)
+	if req.IsNotification() {
+		return nil
+	}
+	if err != nil {
+		return lsp.NewErrorResponse(*req.ID, ToResponseError(err))
+	}
+
+	return NewResponse(*req.ID, result)
+}
+
+// the innermost handler, finds whatever is registered for the method
+func (r *Router) lookup(ctx context.Context, req *Request) (any, error) {
+	handler, ok := r.handlers[req.Method]
+	if !ok {
+		if req.IsNotification() {
+			return nil, nil // unknown notifications (like $/ ones) are fine to ignore
+		}
+		return nil, lsp.NewResponseError(lsp.MethodNotFound, "method not found: %s", req.Method)
+	}
+	return handler(ctx, req)
+}
+
+// a successful reply, result is always there even if it is null
+type Response struct {
+	lsp.Response
+	Result any `json:"result"`
+}
+
+func NewResponse(id lsp.ID, result any) Response {
+	return Response{
+		Response: lsp.Response{
+			RPC: "2.0",
+			ID:  id,
+		},
+		Result: result,
+	}
+}
+
+// ToResponseError keeps the code of a *lsp.ResponseError and turns anything else into an InternalError
+func ToResponseError(err error) *lsp.ResponseError {
+	var responseErr *lsp.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr
+	}
+	return lsp.NewResponseError(lsp.InternalError, "%s", err)
+}
+
+// DecodeRequest pulls the envelope out of a message. when that fails it hands back
+// the error response that should be sent instead. responses from the client come back as nil, nil
+func DecodeRequest(content []byte) (*Request, *lsp.Response) {
+	req, _, errResponse := decode(content)
+	return req, errResponse
+}
+
+// the client's answer to a request the server sent
+type clientResponse struct {
+	ID     lsp.ID
+	Result json.RawMessage
+	Error  *lsp.ResponseError
+}
+
+// decode sorts a message into a request/notification from the client or a response to one of our requests
+func decode(content []byte) (*Request, *clientResponse, *lsp.Response) {
+	var envelope struct {
+		RPC    string             `json:"jsonrpc"`
+		ID     json.RawMessage    `json:"id"`
+		Method string             `json:"method"`
+		Params json.RawMessage    `json:"params"`
+		Result json.RawMessage    `json:"result"`
+		Error  *lsp.ResponseError `json:"error"`
+	}
+	if err := json.Unmarshal(content, &envelope); err != nil {
+		response := lsp.NewErrorResponse(lsp.ID{}, lsp.NewResponseError(lsp.ParseError, "parse error: %s", err))
+		return nil, nil, &response
+	}
+
+	req := &Request{Method: envelope.Method, Params: envelope.Params}
+	if envelope.ID != nil {
+		var id lsp.ID
+		if err := json.Unmarshal(envelope.ID, &id); err != nil {
+			response := lsp.NewErrorResponse(lsp.ID{}, lsp.NewResponseError(lsp.InvalidRequest, "invalid request: %s", err))
+			return nil, nil, &response
+		}
+		req.ID = &id
+	}
+
+	if envelope.Method == "" {
+		// no method means this is a response from the client. replying to a response is not allowed
+		// so if it doesn't even have an id there is nothing to do with it
+		if req.IsNotification() {
+			return nil, nil, nil
+		}
+		return nil, &clientResponse{ID: *req.ID, Result: envelope.Result, Error: envelope.Error}, nil
+	}
+
+	return req, nil, nil
+}
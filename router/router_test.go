@@ -0,0 +1,106 @@
+package router_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"lsp/lsp"
+	"lsp/router"
+	"testing"
+)
+
+type echoParams struct {
+	Text string `json:"text"`
+}
+
+func newTestRouter() *router.Router {
+	rt := router.New()
+	router.Handle(rt, "echo", func(ctx context.Context, params echoParams) (string, error) {
+		return params.Text, nil
+	})
+	router.Notify(rt, "poke", func(ctx context.Context, params echoParams) error {
+		return nil
+	})
+	return rt
+}
+
+func dispatch(t *testing.T, rt *router.Router, msg string) string {
+	t.Helper()
+	reply := rt.Dispatch(context.Background(), []byte(msg))
+	if reply == nil {
+		return ""
+	}
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(encoded)
+}
+
+func TestDispatch(t *testing.T) {
+	rt := newTestRouter()
+
+	tests := map[string]string{
+		`{"jsonrpc":"2.0","id":1,"method":"echo","params":{"text":"hi"}}`:   `{"jsonrpc":"2.0","id":1,"result":"hi"}`,
+		`{"jsonrpc":"2.0","id":"a","method":"echo","params":{"text":"hi"}}`: `{"jsonrpc":"2.0","id":"a","result":"hi"}`,
+		`{"jsonrpc":"2.0","method":"poke","params":{"text":"hi"}}`:          ``,
+		`{"jsonrpc":"2.0","method":"nope"}`:                                 ``,
+	}
+
+	for msg, expected := range tests {
+		if actual := dispatch(t, rt, msg); actual != expected {
+			t.Fatalf("%s: Expected: %s, Actual: %s", msg, expected, actual)
+		}
+	}
+}
+
+func TestDispatchErrors(t *testing.T) {
+	rt := newTestRouter()
+
+	tests := map[string]lsp.ErrorCode{
+		`{"jsonrpc":"2.0","id":1,"method":"nope"}`:                        lsp.MethodNotFound,
+		`{"jsonrpc":"2.0","id":1,"method":"echo","params":{"text":true}}`: lsp.InvalidParams,
+		`{"jsonrpc":"2.0","id":1.5,"method":"echo"}`:                      lsp.InvalidRequest,
+		`{"jsonrpc":"2.0",`: lsp.ParseError,
+	}
+
+	for msg, expected := range tests {
+		var reply lsp.Response
+		if err := json.Unmarshal([]byte(dispatch(t, rt, msg)), &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Error == nil || reply.Error.Code != expected {
+			t.Fatalf("%s: Expected: %d, Actual: %+v", msg, expected, reply.Error)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rt := newTestRouter()
+	router.Handle(rt, "boom", func(ctx context.Context, params echoParams) (string, error) {
+		panic("kaboom")
+	})
+
+	var seen []string
+	rt.Use(func(next router.HandlerFunc) router.HandlerFunc {
+		return func(ctx context.Context, req *router.Request) (any, error) {
+			seen = append(seen, req.Method)
+			return next(ctx, req)
+		}
+	})
+	rt.Use(router.Recover(log.New(io.Discard, "", 0)))
+
+	var reply lsp.Response
+	if err := json.Unmarshal([]byte(dispatch(t, rt, `{"jsonrpc":"2.0","id":7,"method":"boom"}`)), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error == nil || reply.Error.Code != lsp.InternalError {
+		t.Fatalf("Expected: %d, Actual: %+v", lsp.InternalError, reply.Error)
+	}
+
+	dispatch(t, rt, `{"jsonrpc":"2.0","method":"poke"}`)
+	if len(seen) != 2 || seen[0] != "boom" || seen[1] != "poke" {
+		t.Fatalf("Expected: [boom poke], Actual: %v", seen)
+	}
+}
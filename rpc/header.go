@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMissingContentLength   = errors.New("missing Content-Length")
+	ErrDuplicateContentLength = errors.New("duplicate Content-Length")
+	ErrUnsupportedCharset     = errors.New("unsupported charset")
+)
+
+// what the spec says we should assume when the client doesn't send a Content-Type
+const DefaultContentType = "application/vscode-jsonrpc; charset=utf-8"
+
+// the header part of a message, everything before the \r\n\r\n
+type Header struct {
+	ContentLength int
+	ContentType   string
+}
+
+// ParseHeader parses a whole header block, headers are separated by \r\n and
+// the block should not include the trailing empty line
+func ParseHeader(block []byte) (Header, error) {
+	var p headerParser
+	for _, line := range bytes.Split(block, []byte{'\r', '\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		if err := p.parseLine(line); err != nil {
+			return Header{}, err
+		}
+	}
+
+	return p.header()
+}
+
+// keeps track of what we have seen so far so the Reader can feed it one line at a time
+type headerParser struct {
+	contentLength int
+	contentType   string
+	seenLength    bool
+}
+
+func (p *headerParser) parseLine(line []byte) error {
+	name, value, found := bytes.Cut(line, []byte{':'})
+	if !found {
+		return &HeaderError{Line: string(line), Err: errors.New("missing ':'")}
+	}
+
+	key := strings.TrimSpace(string(name))
+	val := strings.TrimSpace(string(value))
+
+	switch {
+	case strings.EqualFold(key, "Content-Length"):
+		if p.seenLength {
+			return &HeaderError{Line: string(line), Err: ErrDuplicateContentLength}
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return &HeaderError{Line: string(line), Err: errors.New("invalid Content-Length")}
+		}
+		p.contentLength = n
+		p.seenLength = true
+
+	case strings.EqualFold(key, "Content-Type"):
+		if err := checkContentType(val); err != nil {
+			return &HeaderError{Line: string(line), Err: err}
+		}
+		p.contentType = val
+	}
+
+	// anything else we just ignore, the spec allows for more headers in the future
+	return nil
+}
+
+func (p *headerParser) header() (Header, error) {
+	if !p.seenLength {
+		return Header{}, &HeaderError{Err: ErrMissingContentLength}
+	}
+
+	contentType := p.contentType
+	if contentType == "" {
+		contentType = DefaultContentType
+	}
+
+	return Header{ContentLength: p.contentLength, ContentType: contentType}, nil
+}
+
+// the content is always json so the only thing worth checking is the charset,
+// utf8 is still accepted for backwards compatibility
+func checkContentType(value string) error {
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return fmt.Errorf("invalid Content-Type: %w", err)
+	}
+
+	charset, ok := params["charset"]
+	if !ok {
+		return nil
+	}
+	if !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "utf8") {
+		return fmt.Errorf("%w %q", ErrUnsupportedCharset, charset)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// returned when the stream ends in the middle of a header block
+var ErrUnexpectedEOF = errors.New("rpc: unexpected EOF in header")
+
+// the header block was malformed, e.g. a line without a colon or a bad Content-Length
+type HeaderError struct {
+	Line string
+	Err  error
+}
+
+func (e *HeaderError) Error() string {
+	if e.Line == "" {
+		return fmt.Sprintf("rpc: bad header: %s", e.Err)
+	}
+	return fmt.Sprintf("rpc: bad header %q: %s", e.Line, e.Err)
+}
+
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+// the stream ended before we got all the bytes Content-Length promised us
+type TruncatedError struct {
+	Expected int
+	Got      int
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("rpc: truncated message body: expected %d bytes, got %d", e.Expected, e.Got)
+}
+
+// Reader reads one framed message at a time from the underlying stream.
+// unlike bufio.Scanner there is no cap on how big a message can be
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the content of the next message (without the header block).
+// io.EOF is returned only when the stream ends cleanly between two messages
+func (r *Reader) Read() ([]byte, error) {
+	var p headerParser
+	first := true
+
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF && first && len(line) == 0 {
+				return nil, io.EOF
+			}
+			if err == io.EOF {
+				return nil, ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		first = false
+
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if len(line) == 0 { // empty line means the header block is done
+			break
+		}
+
+		if err := p.parseLine(line); err != nil {
+			return nil, err
+		}
+	}
+
+	header, err := p.header()
+	if err != nil {
+		return nil, err
+	}
+	contentLength := header.ContentLength
+
+	// the buffer grows as the body comes in, so a length that lies about how much is coming doesn't
+	// get allocated up front
+	var content bytes.Buffer
+	n, err := io.CopyN(&content, r.r, int64(contentLength))
+	if err != nil {
+		if err == io.EOF {
+			return nil, &TruncatedError{Expected: contentLength, Got: int(n)}
+		}
+		return nil, err
+	}
+
+	return content.Bytes(), nil
+}
+
+// ReadMessage is Read followed by pulling the method out of the content, same as DecodeMessage
+func (r *Reader) ReadMessage() (string, []byte, error) {
+	content, err := r.Read()
+	if err != nil {
+		return "", nil, err
+	}
+
+	method, err := decodeMethod(content)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return method, content, nil
+}
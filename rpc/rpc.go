@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
 )
 
 // takes in some message type and returns a string
@@ -31,19 +30,31 @@ func DecodeMessage(msg []byte) (string, []byte, error) {
 		return "", nil, errors.New("Did not find seperator")
 	}
 		
-	// Content-Length: <number> 
-	contentLengthBytes := header[len("Content-Length: "):] // gives back the bytes after content length header
-	contentLength, err := strconv.Atoi(string(contentLengthBytes)) // converting bytes string to integer
+	parsed, err := ParseHeader(header) // Content-Length: <number>, maybe a Content-Type too
 	if err != nil {
 		return "", nil, err
-	}	
+	}
+	contentLength := parsed.ContentLength
+	if len(content) < contentLength {
+		return "", nil, &TruncatedError{Expected: contentLength, Got: len(content)}
+	}
 
-	var baseMessage BaseMessage
-	if err:= json.Unmarshal(content[:contentLength], &baseMessage); err != nil { // unpacking the message with unmarshall
+	method, err := decodeMethod(content[:contentLength])
+	if err != nil {
 		return "", nil, err
 	}
 
-	return baseMessage.Method, content[:contentLength], nil
+	return method, content[:contentLength], nil
+}
+
+// unpacks just enough of the json to know which method this message is for
+func decodeMethod(content []byte) (string, error) {
+	var baseMessage BaseMessage
+	if err := json.Unmarshal(content, &baseMessage); err != nil { // unpacking the message with unmarshall
+		return "", err
+	}
+
+	return baseMessage.Method, nil
 }
 
 // so that lsp can check for the content length, how many bytes that is gonna be, has it gotten that many things?
@@ -55,12 +66,11 @@ func Split(data []byte, _ bool) (advance int, token []byte, err error) {
 		return 0, nil, nil // not returning an error because here it just means we are not ready yet and are waiting for data
 	}
 	
-	// Content-Length: <number>
-	contentLengthBytes := header[len("Content-Length: "):]
-	contentLength, err := strconv.Atoi(string(contentLengthBytes)) 
+	parsed, err := ParseHeader(header)
 	if err != nil {
 		return 0, nil, err // sending here because either content length was not specified or it doesnt know what to do with the msg
 	}
+	contentLength := parsed.ContentLength
 	
 	if len(content) < contentLength { // this means we have not read enough bytes so we need to wait till we are done
 		return 0, nil, nil
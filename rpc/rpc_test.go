@@ -1,7 +1,12 @@
 package rpc_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"lsp/rpc"
+	"strings"
 	"testing"
 )
 
@@ -21,7 +26,7 @@ func TestDecode(t *testing.T) {
 	incomingMessage := "Content-Length: 15\r\n\r\n{\"Method\":\"hi\"}"
 	method, content, err := rpc.DecodeMessage([]byte(incomingMessage))
 	contentLength := len(content)
-		if err != nil {
+	if err != nil {
 		t.Fatal(err)
 	}
 
@@ -33,3 +38,91 @@ func TestDecode(t *testing.T) {
 		t.Fatalf("Expected: 'hi', Got: %s", method)
 	}
 }
+
+func TestReaderLargeMessage(t *testing.T) {
+	// way bigger than bufio.Scanner's default 64KiB token limit
+	text := strings.Repeat("a", 200*1024)
+	content := fmt.Sprintf("{\"method\":\"hi\",\"text\":%q}", text)
+	stream := rpc.EncodeMessage(json.RawMessage(content)) + "Content-Length: 15\r\n\r\n{\"method\":\"yo\"}"
+
+	reader := rpc.NewReader(strings.NewReader(stream))
+
+	method, actual, err := reader.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != "hi" || len(actual) != len(content) {
+		t.Fatalf("Expected: 'hi' with %d bytes, Got: %q with %d bytes", len(content), method, len(actual))
+	}
+
+	method, _, err = reader.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != "yo" {
+		t.Fatalf("Expected: 'yo', Got: %s", method)
+	}
+
+	if _, err := reader.Read(); err != io.EOF {
+		t.Fatalf("Expected: io.EOF, Got: %v", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	var truncatedErr *rpc.TruncatedError
+	_, err := rpc.NewReader(strings.NewReader("Content-Length: 15\r\n\r\n{\"Meth")).Read()
+	if !errors.As(err, &truncatedErr) || truncatedErr.Expected != 15 || truncatedErr.Got != 6 {
+		t.Fatalf("Expected: TruncatedError{15, 6}, Got: %v", err)
+	}
+
+	_, err = rpc.NewReader(strings.NewReader("Content-Length: 4611686018427387904\r\n\r\n{}")).Read()
+	if !errors.As(err, &truncatedErr) || truncatedErr.Expected != 1<<62 || truncatedErr.Got != 2 {
+		t.Fatalf("Expected: TruncatedError{1<<62, 2}, Got: %v", err)
+	}
+
+	var headerErr *rpc.HeaderError
+	_, err = rpc.NewReader(strings.NewReader("Content-Length: lots\r\n\r\n{}")).Read()
+	if !errors.As(err, &headerErr) {
+		t.Fatalf("Expected: HeaderError, Got: %v", err)
+	}
+
+	_, err = rpc.NewReader(strings.NewReader("Content-Len")).Read()
+	if !errors.Is(err, rpc.ErrUnexpectedEOF) {
+		t.Fatalf("Expected: ErrUnexpectedEOF, Got: %v", err)
+	}
+}
+
+func TestDecodeHeaders(t *testing.T) {
+	incomingMessage := "content-type: application/vscode-jsonrpc; charset=utf-8\r\ncontent-length: 15\r\n\r\n{\"Method\":\"hi\"}"
+	method, content, err := rpc.DecodeMessage([]byte(incomingMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if method != "hi" || len(content) != 15 {
+		t.Fatalf("Expected: 'hi' with 15 bytes, Got: %q with %d bytes", method, len(content))
+	}
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	tests := map[string]error{
+		"Content-Type: application/vscode-jsonrpc; charset=utf-8":                        rpc.ErrMissingContentLength,
+		"Content-Length: 15\r\nContent-Length: 15":                                       rpc.ErrDuplicateContentLength,
+		"Content-Length: 15\r\nContent-Type: application/vscode-jsonrpc; charset=latin1": rpc.ErrUnsupportedCharset,
+	}
+
+	for header, expected := range tests {
+		_, err := rpc.ParseHeader([]byte(header))
+		if !errors.Is(err, expected) {
+			t.Fatalf("%q: Expected: %v, Got: %v", header, expected, err)
+		}
+	}
+
+	header, err := rpc.ParseHeader([]byte("Content-Length: 15\r\nContent-Type: application/vscode-jsonrpc; charset=utf8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.ContentLength != 15 {
+		t.Fatalf("Expected: 15, Got: %d", header.ContentLength)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"lsp/compiler"
+	"lsp/lsp"
+	"lsp/router"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// rulesFile keeps the rules from initializationOptions.rulesFile loaded. while the file is open in
+// the editor the buffer is what counts, otherwise whatever is on disk, and the client tells us
+// about changes on disk if it lets us register a file watcher
+type rulesFile struct {
+	logger      *log.Logger
+	conn        *router.Conn
+	state       *compiler.State
+	sess        *session
+	diagnostics *diagnosticsScheduler
+
+	mu   sync.Mutex
+	path string
+	uri  string
+}
+
+func newRulesFile(logger *log.Logger, conn *router.Conn, state *compiler.State, sess *session, diagnostics *diagnosticsScheduler) *rulesFile {
+	return &rulesFile{logger: logger, conn: conn, state: state, sess: sess, diagnostics: diagnostics}
+}
+
+// open points us at the rules file and loads it, relative paths are from our working directory
+func (r *rulesFile) open(path string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		r.logger.Printf("rules file %s: %s", path, err)
+		return
+	}
+	uri := (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+
+	r.mu.Lock()
+	r.path, r.uri = abs, uri
+	r.mu.Unlock()
+
+	r.state.SetRulesFile(uri)
+	r.loadFromDisk()
+}
+
+func (r *rulesFile) is(uri string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.uri != "" && r.uri == uri
+}
+
+func (r *rulesFile) loadFromDisk() {
+	r.mu.Lock()
+	path := r.path
+	r.mu.Unlock()
+	if path == "" {
+		return
+	}
+
+	text, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		text, err = []byte("{}"), nil // deleted, so no rules from it anymore
+	}
+	if err != nil {
+		r.logger.Printf("rules file %s: %s", path, err)
+		return
+	}
+	r.load(string(text))
+}
+
+// load takes the rules from text and gets every open document checked with them
+func (r *rulesFile) load(text string) {
+	if err := r.state.LoadRules(text); err != nil {
+		r.logger.Printf("rules file: %s", err)
+	}
+
+	if r.sess.pullDiagnostics() {
+		if r.sess.client().SupportsDiagnosticRefresh() {
+			// can't wait for the answer here, the read loop that would deliver it might be running us
+			go func() {
+				if err := r.conn.Call(context.Background(), "workspace/diagnostic/refresh", nil, nil); err != nil {
+					r.logger.Printf("workspace/diagnostic/refresh: %s", err)
+				}
+			}()
+		}
+		return
+	}
+	for _, snapshot := range r.state.Snapshots() {
+		r.diagnostics.recheck(snapshot.URI)
+	}
+}
+
+// watch asks the client to tell us when the rules file changes on disk
+func (r *rulesFile) watch() {
+	r.mu.Lock()
+	path := r.path
+	r.mu.Unlock()
+	if path == "" || !r.sess.client().SupportsWatchedFilesRegistration() {
+		return
+	}
+
+	params := lsp.RegistrationParams{Registrations: []lsp.Registration{{
+		ID:     "rules-file",
+		Method: "workspace/didChangeWatchedFiles",
+		RegisterOptions: lsp.DidChangeWatchedFilesRegistrationOptions{
+			Watchers: []lsp.FileSystemWatcher{{GlobPattern: filepath.ToSlash(path)}},
+		},
+	}}}
+	go func() {
+		if err := r.conn.Call(context.Background(), "client/registerCapability", params, nil); err != nil {
+			r.logger.Printf("couldn't watch the rules file: %s", err)
+		}
+	}()
+}
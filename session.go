@@ -0,0 +1,36 @@
+package main
+
+import (
+	"lsp/lsp"
+	"sync"
+)
+
+// session is what we learned about the client during initialize. handlers pass the
+// capabilities down so compiler.State features only send what the editor can handle
+type session struct {
+	mu           sync.RWMutex
+	clientInfo   *lsp.ClientInfo
+	capabilities *lsp.ClientCapabilities
+}
+
+func (s *session) initialize(params lsp.InitializeRequestParams) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clientInfo = params.ClientInfo
+	s.capabilities = &params.Capabilities
+}
+
+// the capabilities are never modified after initialize so it is fine to hand out the pointer.
+// before initialize this is nil, which the lsp helpers treat as "supports nothing"
+func (s *session) client() *lsp.ClientCapabilities {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.capabilities
+}
+
+// pullDiagnostics is true once the client said it asks for diagnostics itself, then we stop pushing them
+func (s *session) pullDiagnostics() bool {
+	return s.client().SupportsPullDiagnostics()
+}